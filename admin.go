@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"whos-your-mate/config"
+)
+
+// adminAuth is the password of the admin endpoints; main sets it from the
+// configuration. The admin endpoints are disabled while it is empty.
+var adminAuth string
+
+// adminLogins throttles guessing the admin password. It is kept apart from
+// the game logins, so that logging in to a game never resets it.
+var adminLogins = newLoginLimiter()
+
+// requireAdmin protects admin endpoints with HTTP basic authentication.
+// Any user name is accepted, the password must be the admin password.
+// Failed attempts lead to the same backoff as game logins. Browsers
+// send cached credentials along with requests of other sites, so requests
+// that change something must come from the server's own pages.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminAuth == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && crossSite(r) {
+			http.Error(w, "Cross-site request refused", http.StatusForbidden)
+			return
+		}
+
+		_, password, ok := r.BasicAuth()
+		if ok {
+			client := clientIP(r)
+			matched := passwordMatches(password, adminAuth)
+			result := adminLogins.attempt(client, "", matched) // the admin password is not one of a deck
+			if !result.allowed {
+				tooManyLogins(w, result.wait)
+				return
+			}
+			if result.blocked > 0 {
+				log.Printf("Failed admin login from %s (%d failures), blocked for %s\n", client, result.failures, result.blocked)
+			}
+			ok = matched
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// crossSite reports whether a request was sent by a page of another
+// origin, as told by Sec-Fetch-Site or, from older browsers, by Origin.
+// Requests without either header do not come from a browser page.
+func crossSite(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || !strings.EqualFold(u.Host, r.Host)
+}
+
+// isJSON reports whether the body of a request is declared as JSON. Pages
+// of other sites cannot send such a request without a CORS preflight.
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// adminStatsHandler reports the per-image statistics of all decks, or of
+// the deck given with ?deck=, as JSON or with ?format=csv as CSV
+func adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var deck string
+	if r.URL.Query().Get("deck") != "" {
+		deck = deckOf(r).Name
+	}
+	report := stats.report(deck)
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			respondWithError(w, "Could not encode statistics", err)
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="stats.csv"`)
+		if err := report.writeCSV(w); err != nil {
+			log.Println("Could not write statistics:", err)
+		}
+	default:
+		http.Error(w, "format must be json or csv, got "+strconv.Quote(format), http.StatusBadRequest)
+	}
+}
+
+// statsCommand implements the stats subcommand, which prints the image
+// statistics saved in the data directory:
+//
+//	whos-your-mate stats [-format csv|json] [-deck name] [config flags]
+func statsCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	config.RegisterFlags(fs)
+	format := fs.String("format", "csv", "output format, csv or json")
+	deck := fs.String("deck", "", "only report the images of this deck")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := config.Init(fs); err != nil {
+		return fmt.Errorf("Invalid configuration:\n%v", err)
+	}
+	return printStats(out, filepath.Join(config.Env().DataDir, "answers.jsonl"), *deck, *format)
+}
+
+// printStats writes the report of the statistics saved at path
+func printStats(out io.Writer, path, deck, format string) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("format must be csv or json, got %q", format)
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no statistics at %s yet", path)
+	}
+	s, err := openStatsLog(path)
+	if err != nil {
+		return err
+	}
+
+	report := s.report(deck)
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return report.writeCSV(out)
+}
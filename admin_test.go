@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"whos-your-mate/config"
+)
+
+// withAdmin sets the admin password and statistics for the duration of a test
+func withAdmin(t *testing.T, password string, s *statsLog) {
+	t.Helper()
+	savedAuth, savedStats := adminAuth, stats
+	adminAuth, stats = password, s
+	logins, adminLogins = newLoginLimiter(), newLoginLimiter()
+	t.Cleanup(func() {
+		adminAuth, stats = savedAuth, savedStats
+		logins, adminLogins = newLoginLimiter(), newLoginLimiter()
+	})
+}
+
+// TestRequireAdmin tests the admin password check
+func TestRequireAdmin(t *testing.T) {
+	handler := requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	withAdmin(t, "", newStatsLog())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected disabled admin endpoints to 404, got %d", w.Code)
+	}
+
+	withAdmin(t, "s3cret", newStatsLog())
+	tests := []struct {
+		name     string
+		password string
+		status   int
+	}{
+		{"no credentials", "", http.StatusUnauthorized},
+		{"wrong password", "guess", http.StatusUnauthorized},
+		{"admin password", "s3cret", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+			if tt.password != "" {
+				req.SetBasicAuth("admin", tt.password)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a basic authentication challenge")
+			}
+		})
+	}
+}
+
+// TestRequireAdminLockout tests that game logins do not reset the failures
+// of guessing the admin password
+func TestRequireAdminLockout(t *testing.T) {
+	withAdmin(t, "s3cret", newStatsLog())
+	handler := requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	deck := &config.Deck{Name: "alice", APIAuth: "alice-pw"}
+
+	for i := 0; i < loginFreeAttempts; i++ {
+		w := httptest.NewRecorder()
+		loginHandler(w, loginRequest(deck, "alice-pw"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected the game login to succeed, got %d", w.Code)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+		req.SetBasicAuth("admin", "guess")
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401 on guess %d, got %d", i+1, w.Code)
+		}
+	}
+
+	loginHandler(httptest.NewRecorder(), loginRequest(deck, "alice-pw"))
+	req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	req.SetBasicAuth("admin", "s3cret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the admin guesses to stay blocked, got %d", w.Code)
+	}
+}
+
+// TestRequireAdminCrossSite tests that changes are only accepted from the
+// server's own pages
+func TestRequireAdminCrossSite(t *testing.T) {
+	withAdmin(t, "s3cret", newStatsLog())
+	handler := requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{"same origin", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://example.com"}, http.StatusNoContent},
+		{"no browser", http.MethodDelete, nil, http.StatusNoContent},
+		{"cross site", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"same site", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{"other origin", http.MethodDelete, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"own origin", http.MethodPost, map[string]string{"Origin": "http://example.com"}, http.StatusNoContent},
+		{"cross site read", http.MethodGet, map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/images", nil)
+			req.SetBasicAuth("admin", "s3cret")
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+// TestAdminStatsHandler tests the statistics report as JSON and CSV
+func TestAdminStatsHandler(t *testing.T) {
+	s := newStatsLog()
+	s.record(answerStat{Deck: "alice", Images: []string{"a.jpg", "b.jpg"}, Correct: 0, Picked: 1, ResponseMs: 3000})
+	s.record(answerStat{Deck: "alice", Images: []string{"a.jpg", "c.jpg"}, Correct: 0, Picked: 0, ResponseMs: 1000})
+	s.record(answerStat{Deck: "bob", Images: []string{"x.jpg", "y.jpg"}, Correct: 0, Picked: 0})
+	withAdmin(t, "s3cret", s)
+
+	w := httptest.NewRecorder()
+	adminStatsHandler(w, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	var report StatsReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(report.Images) != 5 {
+		t.Fatalf("Expected 5 images, got %+v", report.Images)
+	}
+	first := report.Images[0]
+	if first.Deck != "alice" || first.Image != "b.jpg" || first.Fooled != 1 || first.MeanResponseMs != 3000 {
+		t.Errorf("Expected the fooling image first, got %+v", first)
+	}
+	if a := report.Images[1]; a.Image != "a.jpg" || a.Shown != 2 || a.Correct != 1 || a.Incorrect != 1 || a.MeanResponseMs != 2000 {
+		t.Errorf("Unexpected stats of a.jpg: %+v", a)
+	}
+
+	w = httptest.NewRecorder()
+	adminStatsHandler(w, httptest.NewRequest(http.MethodGet, "/admin/stats?format=csv", nil))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 6 || lines[0] != "deck,image,shown,correct,incorrect,fooled,mean_response_ms" || lines[1] != "alice,b.jpg,1,0,1,1,3000" {
+		t.Errorf("Unexpected CSV:\n%s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	adminStatsHandler(w, httptest.NewRequest(http.MethodGet, "/admin/stats?format=xml", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown format, got %d", w.Code)
+	}
+}
+
+// TestPrintStats tests the report of the stats subcommand
+func TestPrintStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answers.jsonl")
+	if err := printStats(&bytes.Buffer{}, path, "", "csv"); err == nil || !strings.Contains(err.Error(), "no statistics") {
+		t.Errorf("Expected missing statistics error, got %v", err)
+	}
+
+	s, err := openStatsLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.record(answerStat{Deck: "alice", Images: []string{"a.jpg", "b.jpg"}, Correct: 0, Picked: 0})
+	s.record(answerStat{Deck: "bob", Images: []string{"x.jpg", "y.jpg"}, Correct: 0, Picked: 0})
+
+	var out bytes.Buffer
+	if err := printStats(&out, path, "bob", "json"); err != nil {
+		t.Fatal(err)
+	}
+	var report StatsReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(report.Images) != 2 || report.Images[0].Deck != "bob" {
+		t.Errorf("Expected only the images of bob, got %+v", report.Images)
+	}
+
+	if err := printStats(&out, path, "", "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultSessionTTL is used until main configures the session signer
+const defaultSessionTTL = 12 * time.Hour
+
+var (
+	errInvalidSession = errors.New("invalid session token")
+	errSessionExpired = errors.New("session expired")
+)
+
+// sessionClaims is the signed content of a session token
+type sessionClaims struct {
+	ID      string `json:"sid"`
+	Deck    string `json:"deck"`
+	Issued  int64  `json:"iat"` // unix seconds
+	Expires int64  `json:"exp"` // unix seconds
+}
+
+// LoginRequest is the body of a POST to /login
+type LoginRequest struct {
+	Password string `json:"password"`
+}
+
+// LoginResponse carries the session token for clients that prefer a bearer
+// token over the cookie
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// sessionSigner issues and verifies HMAC-signed session tokens of the form
+// base64url(claims) + "." + base64url(HMAC-SHA256(secret, claims))
+type sessionSigner struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// sessions signs the sessions of all decks; main replaces it with one
+// using the configured secret
+var sessions = newSessionSigner(randomSecret(), defaultSessionTTL)
+
+func newSessionSigner(secret []byte, ttl time.Duration) *sessionSigner {
+	return &sessionSigner{secret: secret, ttl: ttl, now: time.Now}
+}
+
+// issue creates a token granting access to the given deck
+func (s *sessionSigner) issue(deck string) (string, sessionClaims, error) {
+	id, err := newID()
+	if err != nil {
+		return "", sessionClaims{}, err
+	}
+	return s.renew(id, deck)
+}
+
+// renew creates a token for the session of the given ID, so that a player
+// logging in again keeps the state kept for their session
+func (s *sessionSigner) renew(id, deck string) (string, sessionClaims, error) {
+	now := s.now()
+	claims := sessionClaims{ID: id, Deck: deck, Issued: now.Unix(), Expires: now.Add(s.ttl).Unix()}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", sessionClaims{}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(encoded), claims, nil
+}
+
+// verify checks the signature and expiry of a token and returns its claims
+func (s *sessionSigner) verify(token string) (sessionClaims, error) {
+	var claims sessionClaims
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(encoded))) {
+		return claims, errInvalidSession
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, errInvalidSession
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, errInvalidSession
+	}
+	if s.now().Unix() >= claims.Expires {
+		return claims, errSessionExpired
+	}
+	return claims, nil
+}
+
+func (s *sessionSigner) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionCookieName is per deck so players can be logged in to several
+func sessionCookieName(deck string) string {
+	return "wym_session_" + deck
+}
+
+// sessionFrom returns the session of the request for the requested deck,
+// taken from an "Authorization: Bearer" header or the deck's cookie
+func sessionFrom(r *http.Request) (sessionClaims, error) {
+	deck := deckOf(r).Name
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		cookie, err := r.Cookie(sessionCookieName(deck))
+		if err != nil {
+			return sessionClaims{}, errInvalidSession
+		}
+		token = cookie.Value
+	}
+
+	claims, err := sessions.verify(token)
+	if err != nil {
+		return claims, err
+	}
+	if claims.Deck != deck {
+		return claims, errInvalidSession
+	}
+	// Rotating the password may have signed everyone out
+	if claims.Issued < passwords.signedOutAt(deck).Unix() {
+		return claims, errSessionExpired
+	}
+	return claims, nil
+}
+
+// requireSession rejects requests without a valid session for the
+// requested deck
+func requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := sessionFrom(r); err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loginHandler exchanges the deck password for a session cookie and token
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid login payload", http.StatusBadRequest)
+		return
+	}
+
+	deck := deckOf(r)
+	client := clientIP(r)
+	matched := passwordMatches(req.Password, passwords.password(deck))
+	result := logins.attempt(client, deck.Name, matched)
+	if !result.allowed {
+		tooManyLogins(w, result.wait)
+		return
+	}
+	if !matched {
+		if result.blocked > 0 {
+			log.Printf("Failed login for deck %s from %s (%d failures), blocked for %s\n", deck.Name, client, result.failures, result.blocked)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The frontend logs in before every game; a player still holding a
+	// valid session keeps it, and with it their history of seen images
+	var token string
+	var claims sessionClaims
+	var err error
+	if current, sessionErr := sessionFrom(r); sessionErr == nil {
+		token, claims, err = sessions.renew(current.ID, deck.Name)
+	} else {
+		token, claims, err = sessions.issue(deck.Name)
+	}
+	if err != nil {
+		respondWithError(w, "Could not create session", err)
+		return
+	}
+	expires := time.Unix(claims.Expires, 0)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName(deck.Name),
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: cookieSameSite(r),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(LoginResponse{Token: token, ExpiresAt: expires}); err != nil {
+		respondWithError(w, "Could not encode session", err)
+	}
+}
+
+// logoutHandler clears the session cookie of the requested deck
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName(deckOf(r).Name),
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: cookieSameSite(r),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cookieSameSite keeps session cookies first-party unless cross-site
+// requests may carry credentials; browsers require Secure for SameSite=None
+func cookieSameSite(r *http.Request) http.SameSite {
+	if cors.credentials && r.TLS != nil {
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// randomSecret returns 32 random bytes for signing sessions
+func randomSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// TestSessionSigner tests issuing and verifying session tokens
+func TestSessionSigner(t *testing.T) {
+	signer := newSessionSigner([]byte("secret"), time.Hour)
+	token, claims, err := signer.issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(token, "secret") {
+		t.Errorf("Token leaks the secret: %s", token)
+	}
+
+	got, err := signer.verify(token)
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if got != claims || got.Deck != "alice" || got.ID == "" {
+		t.Errorf("Unexpected claims: %+v", got)
+	}
+}
+
+// TestSessionSignerRejects tests tampered, foreign and expired tokens
+func TestSessionSignerRejects(t *testing.T) {
+	signer := newSessionSigner([]byte("secret"), time.Hour)
+	token, _, err := signer.issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newSessionSigner([]byte("other-secret"), time.Hour)
+	foreign, _, err := other.issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"empty", "", errInvalidSession},
+		{"no signature", payload, errInvalidSession},
+		{"tampered payload", payload + "x." + sig, errInvalidSession},
+		{"tampered signature", payload + "." + sig + "x", errInvalidSession},
+		{"other secret", foreign, errInvalidSession},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := signer.verify(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+
+	signer.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := signer.verify(token); !errors.Is(err, errSessionExpired) {
+		t.Errorf("Expected errSessionExpired, got %v", err)
+	}
+}
+
+// TestLoginHandler tests exchanging the password for a session
+func TestLoginHandler(t *testing.T) {
+	deck, _ := config.Env().Deck(config.DefaultDeck)
+
+	body, _ := json.Marshal(LoginRequest{Password: deck.APIAuth})
+	w := httptest.NewRecorder()
+	loginHandler(w, httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var resp LoginResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Token == "" || resp.ExpiresAt.Before(time.Now()) {
+		t.Errorf("Unexpected login response: %+v", resp)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookieName(config.DefaultDeck) || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly session cookie, got %+v", cookies)
+	}
+
+	// The cookie authorizes requests to the deck
+	req := httptest.NewRequest(http.MethodGet, "/game-data", nil)
+	req.AddCookie(cookies[0])
+	if _, err := sessionFrom(req); err != nil {
+		t.Errorf("Expected cookie session to be valid, got %v", err)
+	}
+
+	// The bearer token authorizes requests to the deck
+	req = httptest.NewRequest(http.MethodGet, "/game-data", nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Token)
+	if _, err := sessionFrom(req); err != nil {
+		t.Errorf("Expected bearer session to be valid, got %v", err)
+	}
+
+	// Logging in again with a valid session keeps it
+	claims, _ := sessions.verify(resp.Token)
+	req = httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	loginHandler(w, req)
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if renewed, _ := sessions.verify(resp.Token); renewed.ID != claims.ID {
+		t.Errorf("Expected session %s to be kept, got %s", claims.ID, renewed.ID)
+	}
+}
+
+// TestLoginHandlerRejects tests invalid login attempts
+func TestLoginHandlerRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"malformed body", http.MethodPost, "{", http.StatusBadRequest},
+		{"wrong password", http.MethodPost, `{"password":"definitely-wrong"}`, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			loginHandler(w, httptest.NewRequest(tt.method, "/login", bytes.NewBufferString(tt.body)))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if len(w.Result().Cookies()) != 0 {
+				t.Error("Expected no session cookie")
+			}
+		})
+	}
+}
+
+// TestSessionFromOtherDeck tests that a session only grants its own deck
+func TestSessionFromOtherDeck(t *testing.T) {
+	token, _, err := sessions.issue("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/game-data", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if _, err := sessionFrom(req); !errors.Is(err, errInvalidSession) {
+		t.Errorf("Expected errInvalidSession for another deck's token, got %v", err)
+	}
+}
+
+// TestLogoutHandler tests that logging out expires the cookie
+func TestLogoutHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	logoutHandler(w, httptest.NewRequest(http.MethodPost, "/logout", nil))
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected an expired session cookie, got %+v", cookies)
+	}
+}
+
+// TestRequireSession tests that protected handlers need a valid session
+func TestRequireSession(t *testing.T) {
+	validToken, _, err := sessions.issue(config.DefaultDeck)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"Request without session should return 401", "", http.StatusUnauthorized},
+		{"Request with invalid token should return 401", "wrong-token", http.StatusUnauthorized},
+		{"Request with valid session should return 200", validToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler := requireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// imageSet is a snapshot of the indexed game images
+type imageSet struct {
+	ChoiceA   []string
+	ChoiceB   []string
+	Ending    []string
+	Endings   map[string][]string  // ending images by score tier; shared, do not modify
+	Manifest  *questionManifest    // nil without a manifest; shared, do not modify
+	Meta      map[string]ImageMeta // by image path; shared, do not modify
+	ScannedAt time.Time
+
+	// Weight weighs random images when they are drawn for a game; nil draws
+	// them uniformly. Snapshots have none.
+	Weight func(img string) float64
+}
+
+// reveal returns the metadata of the given images, or nil if none of them
+// has any
+func (s imageSet) reveal(images []string) []ImageMeta {
+	var reveal []ImageMeta
+	for i, img := range images {
+		if m, ok := s.Meta[img]; ok {
+			if reveal == nil {
+				reveal = make([]ImageMeta, len(images))
+			}
+			reveal[i] = m
+		}
+	}
+	return reveal
+}
+
+// describe fills in the metadata and files of the answer keys of questions
+// built from the set
+func (s imageSet) describe(questions []Question, answers []answerKey) {
+	for i, q := range questions {
+		answers[i].Reveal = s.reveal(q.Options)
+		answers[i].Images = slices.Clone(q.Options)
+	}
+}
+
+// imageCatalog indexes the image directories in memory so that question
+// generation does not have to walk the disk on every request
+type imageCatalog struct {
+	choiceADir string
+	choiceBDir string
+	endingDir  string
+	manifest   string
+
+	mu     sync.RWMutex
+	images imageSet
+}
+
+// newImageCatalog indexes the given directories and, unless manifest is
+// empty, the curated questions of the manifest file
+func newImageCatalog(choiceADir, choiceBDir, endingDir, manifest string) *imageCatalog {
+	return &imageCatalog{
+		choiceADir: choiceADir,
+		choiceBDir: choiceBDir,
+		endingDir:  endingDir,
+		manifest:   manifest,
+	}
+}
+
+// dir returns the directory of the images of a kind: choice_a, choice_b
+// or ending
+func (c *imageCatalog) dir(kind string) (string, bool) {
+	switch kind {
+	case "choice_a":
+		return c.choiceADir, true
+	case "choice_b":
+		return c.choiceBDir, true
+	case "ending":
+		return c.endingDir, true
+	}
+	return "", false
+}
+
+// Refresh rescans all directories, image metadata and the manifest, then
+// swaps in the new index. On error the previous index is kept.
+func (c *imageCatalog) Refresh() error {
+	choiceA, err := loadImages(c.choiceADir)
+	if err != nil {
+		return err
+	}
+	choiceB, err := loadImages(c.choiceBDir)
+	if err != nil {
+		return err
+	}
+	ending, err := loadImages(c.endingDir)
+	if err != nil {
+		return err
+	}
+	meta, err := loadImageMeta(slices.Concat(choiceA, choiceB, ending))
+	if err != nil {
+		return err
+	}
+	var manifest *questionManifest
+	if c.manifest != "" {
+		if manifest, err = loadManifest(c.manifest, c.choiceADir, c.choiceBDir); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.images = imageSet{
+		ChoiceA:   choiceA,
+		ChoiceB:   choiceB,
+		Ending:    ending,
+		Endings:   groupEndings(c.endingDir, ending, meta),
+		Manifest:  manifest,
+		Meta:      meta,
+		ScannedAt: time.Now(),
+	}
+	return nil
+}
+
+// Snapshot returns a copy of the current index that callers may reorder
+func (c *imageCatalog) Snapshot() imageSet {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return imageSet{
+		ChoiceA:   slices.Clone(c.images.ChoiceA),
+		ChoiceB:   slices.Clone(c.images.ChoiceB),
+		Ending:    slices.Clone(c.images.Ending),
+		Endings:   c.images.Endings,
+		Manifest:  c.images.Manifest,
+		Meta:      c.images.Meta,
+		ScannedAt: c.images.ScannedAt,
+	}
+}
+
+// Watch refreshes the catalog every interval and whenever a signal arrives
+// on trigger, until stop is closed. A zero interval disables polling.
+func (c *imageCatalog) Watch(interval time.Duration, trigger <-chan os.Signal, stop <-chan struct{}) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick:
+		case sig := <-trigger:
+			log.Printf("Received %s, rescanning images\n", sig)
+		}
+		if err := c.Refresh(); err != nil {
+			log.Println("Could not rescan images:", err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCatalogDirs creates choice_a, choice_b and ending directories
+// holding the given number of images each
+func newTestCatalogDirs(t *testing.T, choiceA, choiceB, ending int) (string, string, string) {
+	t.Helper()
+	root := t.TempDir()
+	dirs := []string{
+		filepath.Join(root, "choice_a"),
+		filepath.Join(root, "choice_b"),
+		filepath.Join(root, "ending"),
+	}
+	for i, n := range []int{choiceA, choiceB, ending} {
+		if err := os.MkdirAll(dirs[i], 0755); err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < n; j++ {
+			name := filepath.Join(dirs[i], "img"+string(rune('a'+j))+".jpg")
+			if err := os.WriteFile(name, []byte("content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dirs[0], dirs[1], dirs[2]
+}
+
+// TestImageCatalogRefresh tests indexing and re-indexing the directories
+func TestImageCatalogRefresh(t *testing.T) {
+	choiceA, choiceB, ending := newTestCatalogDirs(t, 2, 3, 1)
+	c := newImageCatalog(choiceA, choiceB, ending, "")
+
+	if err := c.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	images := c.Snapshot()
+	if len(images.ChoiceA) != 2 || len(images.ChoiceB) != 3 || len(images.Ending) != 1 {
+		t.Errorf("Unexpected index sizes: %d, %d, %d", len(images.ChoiceA), len(images.ChoiceB), len(images.Ending))
+	}
+	if images.ScannedAt.IsZero() {
+		t.Error("Expected ScannedAt to be set")
+	}
+
+	// New files only show up after a refresh
+	if err := os.WriteFile(filepath.Join(choiceA, "new.png"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(c.Snapshot().ChoiceA); got != 2 {
+		t.Errorf("Expected 2 images before refresh, got %d", got)
+	}
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(c.Snapshot().ChoiceA); got != 3 {
+		t.Errorf("Expected 3 images after refresh, got %d", got)
+	}
+}
+
+// TestImageCatalogRefreshKeepsIndexOnError tests that a failed rescan keeps the old index
+func TestImageCatalogRefreshKeepsIndexOnError(t *testing.T) {
+	choiceA, choiceB, ending := newTestCatalogDirs(t, 1, 1, 1)
+	c := newImageCatalog(choiceA, choiceB, ending, "")
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.RemoveAll(choiceB); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Refresh(); err == nil {
+		t.Error("Expected error for missing directory, got nil")
+	}
+	if got := len(c.Snapshot().ChoiceB); got != 1 {
+		t.Errorf("Expected previous index to be kept, got %d images", got)
+	}
+}
+
+// TestImageCatalogSnapshotIsCopy tests that callers cannot mutate the index
+func TestImageCatalogSnapshotIsCopy(t *testing.T) {
+	choiceA, choiceB, ending := newTestCatalogDirs(t, 2, 2, 1)
+	c := newImageCatalog(choiceA, choiceB, ending, "")
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := c.Snapshot()
+	original := first.ChoiceA[0]
+	first.ChoiceA[0] = "mutated"
+	if got := c.Snapshot().ChoiceA[0]; got != original {
+		t.Errorf("Expected %s, got %s", original, got)
+	}
+}
+
+// TestImageCatalogWatch tests that a trigger causes a rescan
+func TestImageCatalogWatch(t *testing.T) {
+	choiceA, choiceB, ending := newTestCatalogDirs(t, 1, 1, 1)
+	c := newImageCatalog(choiceA, choiceB, ending, "")
+
+	trigger := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Watch(0, trigger, stop)
+
+	trigger <- os.Interrupt
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.Snapshot().ChoiceA) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Catalog was not refreshed after trigger")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
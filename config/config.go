@@ -2,10 +2,16 @@ package config
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type env struct {
@@ -17,30 +23,392 @@ type env struct {
 	ChoiceBImgDir string
 	EndingImgDir  string
 	QuestionCount int
+	// OptionCount is the number of images shown per question, one of them
+	// from ChoiceAImgDir
+	OptionCount int
+	// Manifest is an optional JSON file of curated questions for the default
+	// deck
+	Manifest string
+	// QuestionTime is how long a question of a speed game may take; 0
+	// disables speed games
+	QuestionTime time.Duration
+	// RescanInterval is how often the image directories are re-indexed
+	RescanInterval time.Duration
+	// MaxUploadMB limits the size of images uploaded through the admin API
+	MaxUploadMB int
+	// SessionSecret signs session tokens; a random secret is used when empty
+	SessionSecret string
+	// SessionTTL is how long a login stays valid
+	SessionTTL time.Duration
+	// CORSOrigins may call the API from another site; "*" allows any origin
+	// and "https://*.example.com" any subdomain
+	CORSOrigins []string
+	// CORSCredentials lets allowed origins send cookies
+	CORSCredentials bool
+	// DataDir holds the leaderboard and other state kept across restarts
+	DataDir string
+	// AdminAuth is the password of the admin endpoints, which are disabled
+	// when it is empty
+	AdminAuth string
+	// Decks always contains the DefaultDeck, built from the settings above
+	Decks []*Deck
+}
+
+// field describes one setting and where it can be read from. Each source
+// hands set the raw string value, so parsing and validation are shared.
+type field struct {
+	key   string // dotted path in the config file
+	env   string // environment variable
+	flag  string // command line flag
+	usage string
+	set   func(e *env, val string) error
+	// list settings are comma-separated in env and flags and may be a
+	// list in the config file
+	list bool
+}
+
+var fields = []field{
+	{key: "server.port", env: "PORT", flag: "port", usage: "HTTP port", set: intField(func(e *env) *int { return &e.Port }, 1, 65535)},
+	{key: "server.auth", env: "API_AUTH", flag: "auth", usage: "game password", set: stringField(func(e *env) *string { return &e.APIAuth })},
+	{key: "server.admin_auth", env: "ADMIN_AUTH", flag: "admin-auth", usage: "admin password, admin endpoints are disabled when empty", set: stringField(func(e *env) *string { return &e.AdminAuth })},
+	{key: "server.session_secret", env: "SESSION_SECRET", flag: "session-secret", usage: "secret used to sign session tokens, random per start when empty", set: stringField(func(e *env) *string { return &e.SessionSecret })},
+	{key: "server.session_ttl", env: "SESSION_TTL", flag: "session-ttl", usage: "how long a login stays valid", set: positiveDurationField(func(e *env) *time.Duration { return &e.SessionTTL })},
+	{key: "server.cors_origins", env: "CORS_ORIGINS", flag: "cors-origins", usage: "comma-separated origins allowed to call the API cross-site, * for any", set: originsField, list: true},
+	{key: "server.cors_credentials", env: "CORS_CREDENTIALS", flag: "cors-credentials", usage: "allow cross-site requests to send cookies", set: boolField(func(e *env) *bool { return &e.CORSCredentials })},
+	{key: "server.data_dir", env: "DATA_DIR", flag: "data-dir", usage: "directory of the leaderboard and other saved state", set: stringField(func(e *env) *string { return &e.DataDir })},
+	{key: "server.static_dir", env: "STATIC_DIR", flag: "static-dir", usage: "directory of the frontend assets", set: stringField(func(e *env) *string { return &e.StaticDir })},
+	{key: "images.dir", env: "IMAGES_DIR", flag: "images-dir", usage: "base directory of the image directories", set: stringField(func(e *env) *string { return &e.ImagesDir })},
+	{key: "images.choice_a", env: "CHOICE_A_IMG_DIR", flag: "choice-a-dir", usage: "directory of the correct answer images", set: stringField(func(e *env) *string { return &e.ChoiceAImgDir })},
+	{key: "images.choice_b", env: "CHOICE_B_IMG_DIR", flag: "choice-b-dir", usage: "directory of the wrong answer images", set: stringField(func(e *env) *string { return &e.ChoiceBImgDir })},
+	{key: "images.ending", env: "ENDING_IMG_DIR", flag: "ending-dir", usage: "directory of the ending images", set: stringField(func(e *env) *string { return &e.EndingImgDir })},
+	{key: "images.rescan_interval", env: "RESCAN_INTERVAL", flag: "rescan-interval", usage: "how often images are re-indexed, 0 disables polling", set: durationField(func(e *env) *time.Duration { return &e.RescanInterval })},
+	{key: "images.max_upload_mb", env: "MAX_UPLOAD_MB", flag: "max-upload-mb", usage: "largest image accepted by the admin upload, in megabytes", set: intField(func(e *env) *int { return &e.MaxUploadMB }, 1, 0)},
+	{key: "game.question_count", env: "QUESTION_COUNT", flag: "question-count", usage: "questions per game", set: intField(func(e *env) *int { return &e.QuestionCount }, 1, 0)},
+	{key: "game.manifest", env: "QUESTION_MANIFEST", flag: "manifest", usage: "JSON file of curated questions", set: stringField(func(e *env) *string { return &e.Manifest })},
+	{key: "game.question_time", env: "QUESTION_TIME", flag: "question-time", usage: "time to answer a question in speed games, 0 disables them", set: durationField(func(e *env) *time.Duration { return &e.QuestionTime })},
+	{key: "game.option_count", env: "OPTION_COUNT", flag: "option-count", usage: "images to choose from per question", set: intField(func(e *env) *int { return &e.OptionCount }, 2, MaxOptionCount)},
 }
 
 var (
 	envInstance *env
+	envErr      error
 	once        sync.Once
 )
 
+// Env returns the loaded configuration. Invalid values fall back to their
+// defaults; use Load or Init to find out whether the configuration is valid.
 func Env() *env {
+	e, _ := Load()
+	return e
+}
+
+// Load reads the configuration once, without command line flags, and
+// reports any invalid values
+func Load() (*env, error) {
+	err := Init(nil)
+	return envInstance, err
+}
+
+// Init reads the configuration once from, in increasing order of
+// precedence, the defaults, the config file, the environment (including
+// .env) and the flags set on fs. fs must have been set up with
+// RegisterFlags and parsed; it may be nil.
+func Init(fs *flag.FlagSet) error {
 	once.Do(func() {
 		if err := loadDotEnv(".env"); err != nil {
 			fmt.Println("Error loading .env file:", err)
 		}
-		envInstance = &env{
-			Port:          8080,
-			APIAuth:       os.Getenv("API_AUTH"),
-			StaticDir:     "./static",
-			ImagesDir:     "./images",
-			ChoiceAImgDir: "./images/choice_a",
-			ChoiceBImgDir: "./images/choice_b",
-			EndingImgDir:  "./images/ending",
-			QuestionCount: 5,
+		envInstance, envErr = load(fs)
+	})
+	return envErr
+}
+
+// RegisterFlags adds a flag for every setting, plus -config, to fs
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.String("config", "", "path to a JSON or YAML config file (env CONFIG_FILE)")
+	for _, f := range fields {
+		fs.String(f.flag, "", f.usage+" (env "+f.env+")")
+	}
+}
+
+// defaults returns the configuration used when no source sets a value.
+// The choice directories are derived from ImagesDir by finalize.
+func defaults() *env {
+	return &env{
+		Port:           8080,
+		StaticDir:      "./static",
+		DataDir:        "./data",
+		ImagesDir:      "./images",
+		QuestionCount:  5,
+		OptionCount:    2,
+		QuestionTime:   15 * time.Second,
+		RescanInterval: time.Minute,
+		MaxUploadMB:    10,
+		SessionTTL:     12 * time.Hour,
+	}
+}
+
+// load applies every configuration source on top of the defaults and
+// collects all invalid values into a single error
+func load(fs *flag.FlagSet) (*env, error) {
+	var errs []error
+	e := defaults()
+
+	path := os.Getenv("CONFIG_FILE")
+	if fs != nil {
+		if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+			path = f.Value.String()
+		}
+	}
+	if path != "" {
+		errs = append(errs, applyFile(e, path)...)
+	}
+	errs = append(errs, applyEnvironment(e)...)
+	if fs != nil {
+		errs = append(errs, applyFlags(e, fs)...)
+	}
+	finalize(e)
+
+	return e, errors.Join(errs...)
+}
+
+// applyFile sets every value found in the config file at path. Unknown keys
+// and invalid values are reported with their line number.
+func applyFile(e *env, path string) []error {
+	root, err := parseFile(path)
+	if err != nil {
+		return []error{err}
+	}
+
+	known := make(map[string]field, len(fields))
+	for _, f := range fields {
+		known[f.key] = f
+	}
+
+	var errs []error
+	var walk func(prefix string, n *node)
+	walk = func(prefix string, n *node) {
+		for _, key := range n.Keys {
+			child := n.Map[key]
+			dotted := strings.TrimPrefix(prefix+"."+key, ".")
+			if f, ok := known[dotted]; ok {
+				val := child.Value
+				switch {
+				case child.Kind == listNode && f.list:
+					items := make([]string, len(child.List))
+					for i, item := range child.List {
+						items[i] = item.Value
+					}
+					val = strings.Join(items, ",")
+				case child.Kind != scalarNode:
+					errs = append(errs, fmt.Errorf("%s:%d: %s must be a single value", path, child.Line, dotted))
+					continue
+				}
+				if err := f.set(e, val); err != nil {
+					errs = append(errs, fmt.Errorf("%s:%d: %s %w", path, child.Line, dotted, err))
+				}
+				continue
+			}
+			if dotted == "decks" {
+				errs = append(errs, applyDecks(e, path, child)...)
+				continue
+			}
+			if !isSection(dotted) {
+				errs = append(errs, fmt.Errorf("%s:%d: unknown key %q", path, child.Line, dotted))
+				continue
+			}
+			if child.Kind != mapNode {
+				errs = append(errs, fmt.Errorf("%s:%d: %s must be a section of settings", path, child.Line, dotted))
+				continue
+			}
+			walk(dotted, child)
+		}
+	}
+	walk("", root)
+	return errs
+}
+
+// isSection reports whether prefix is a section containing known keys
+func isSection(prefix string) bool {
+	for _, f := range fields {
+		if strings.HasPrefix(f.key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnvironment sets every value found in non-empty environment variables
+func applyEnvironment(e *env) []error {
+	var errs []error
+	for _, f := range fields {
+		val := strings.TrimSpace(os.Getenv(f.env))
+		if val == "" {
+			continue
+		}
+		if err := f.set(e, val); err != nil {
+			errs = append(errs, fmt.Errorf("%s %w", f.env, err))
+		}
+	}
+	return errs
+}
+
+// applyFlags sets every value given explicitly on the command line
+func applyFlags(e *env, fs *flag.FlagSet) []error {
+	byFlag := make(map[string]field, len(fields))
+	for _, f := range fields {
+		byFlag[f.flag] = f
+	}
+
+	var errs []error
+	fs.Visit(func(fl *flag.Flag) {
+		f, ok := byFlag[fl.Name]
+		if !ok {
+			return
+		}
+		if err := f.set(e, fl.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("-%s %w", f.flag, err))
 		}
 	})
-	return envInstance
+	return errs
+}
+
+// finalize fills in values derived from other settings
+func finalize(e *env) {
+	if e.ChoiceAImgDir == "" {
+		e.ChoiceAImgDir = filepath.Join(e.ImagesDir, "choice_a")
+	}
+	if e.ChoiceBImgDir == "" {
+		e.ChoiceBImgDir = filepath.Join(e.ImagesDir, "choice_b")
+	}
+	if e.EndingImgDir == "" {
+		e.EndingImgDir = filepath.Join(e.ImagesDir, "ending")
+	}
+	finalizeDecks(e)
+}
+
+// stringField stores the raw value
+func stringField(ptr func(*env) *string) func(*env, string) error {
+	return func(e *env, val string) error {
+		*ptr(e) = val
+		return nil
+	}
+}
+
+// listField splits a comma-separated value, dropping empty items
+func listField(ptr func(*env) *[]string) func(*env, string) error {
+	return func(e *env, val string) error {
+		var items []string
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*ptr(e) = items
+		return nil
+	}
+}
+
+// originsField stores a list of origins like https://example.com, allowing
+// "*" and a leading "*." wildcard for subdomains
+func originsField(e *env, val string) error {
+	var origins []string
+	if err := listField(func(*env) *[]string { return &origins })(e, val); err != nil {
+		return err
+	}
+	for i, origin := range origins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		origins[i] = origin
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("must be origins like https://example.com, got %q", origin)
+		}
+	}
+	e.CORSOrigins = origins
+	return nil
+}
+
+// boolField parses true/false, 1/0 and the like
+func boolField(ptr func(*env) *bool) func(*env, string) error {
+	return func(e *env, val string) error {
+		b, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", val)
+		}
+		*ptr(e) = b
+		return nil
+	}
+}
+
+// intField parses an integer of at least min and, if max > min, at most max
+func intField(ptr func(*env) *int, min, max int) func(*env, string) error {
+	return func(e *env, val string) error {
+		n, err := parseInt(val, min, max)
+		if err != nil {
+			return err
+		}
+		*ptr(e) = n
+		return nil
+	}
+}
+
+// parseInt parses an integer of at least min and, if max > min, at most max
+func parseInt(val string, min, max int) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil {
+		return 0, fmt.Errorf("must be an integer, got %q", val)
+	}
+	if max > min && (n < min || n > max) {
+		return 0, fmt.Errorf("must be between %d and %d, got %d", min, max, n)
+	}
+	if n < min {
+		return 0, fmt.Errorf("must be at least %d, got %d", min, n)
+	}
+	return n, nil
+}
+
+// durationField parses a non-negative duration such as "30s" or "5m"
+func durationField(ptr func(*env) *time.Duration) func(*env, string) error {
+	return func(e *env, val string) error {
+		d, err := parseDuration(val)
+		if err != nil {
+			return err
+		}
+		*ptr(e) = d
+		return nil
+	}
+}
+
+// positiveDurationField parses a duration longer than zero, for settings
+// where zero would make no sense
+func positiveDurationField(ptr func(*env) *time.Duration) func(*env, string) error {
+	return func(e *env, val string) error {
+		d, err := parseDuration(val)
+		if err != nil {
+			return err
+		}
+		if d == 0 {
+			return fmt.Errorf("must be longer than 0, got %q", val)
+		}
+		*ptr(e) = d
+		return nil
+	}
+}
+
+// parseDuration parses a non-negative duration
+func parseDuration(val string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(val))
+	if err != nil {
+		return 0, fmt.Errorf("must be a duration like 30s or 5m, got %q", val)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("must not be negative, got %s", d)
+	}
+	return d, nil
 }
 
 func loadDotEnv(filepath string) error {
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"flag"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 // TestEnv tests the singleton pattern and default values
@@ -38,6 +41,7 @@ func TestEnvWithEnvironmentVariable(t *testing.T) {
 
 	// Reset the singleton to test with new environment
 	envInstance = nil
+	envErr = nil
 	once = sync.Once{}
 
 	// Get environment instance
@@ -49,6 +53,288 @@ func TestEnvWithEnvironmentVariable(t *testing.T) {
 	}
 }
 
+// configKeys lists every environment variable read by load
+var configKeys = []string{
+	"PORT", "API_AUTH", "STATIC_DIR", "IMAGES_DIR", "CHOICE_A_IMG_DIR",
+	"CHOICE_B_IMG_DIR", "ENDING_IMG_DIR", "QUESTION_COUNT", "RESCAN_INTERVAL",
+	"CONFIG_FILE", "SESSION_SECRET", "SESSION_TTL", "CORS_ORIGINS", "CORS_CREDENTIALS",
+	"DATA_DIR", "OPTION_COUNT", "QUESTION_MANIFEST", "ADMIN_AUTH", "QUESTION_TIME",
+	"MAX_UPLOAD_MB",
+}
+
+// clearConfigEnv blanks all configuration variables for the duration of a test
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range configKeys {
+		t.Setenv(key, "")
+	}
+}
+
+// TestLoadEnvironmentDefaults tests the default configuration values
+func TestLoadEnvironmentDefaults(t *testing.T) {
+	clearConfigEnv(t)
+
+	e, err := load(nil)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	if e.Port != 8080 {
+		t.Errorf("Expected Port to be 8080, got %d", e.Port)
+	}
+	if e.StaticDir != "./static" || e.ImagesDir != "./images" {
+		t.Errorf("Unexpected directories: %s, %s", e.StaticDir, e.ImagesDir)
+	}
+	if e.ChoiceAImgDir != filepath.Join("images", "choice_a") {
+		t.Errorf("Expected ChoiceAImgDir to be derived from ImagesDir, got %s", e.ChoiceAImgDir)
+	}
+	if e.QuestionCount != 5 || e.OptionCount != 2 {
+		t.Errorf("Expected 5 questions with 2 options, got %d, %d", e.QuestionCount, e.OptionCount)
+	}
+	if e.RescanInterval != time.Minute {
+		t.Errorf("Expected RescanInterval to be 1m, got %s", e.RescanInterval)
+	}
+	if e.MaxUploadMB != 10 {
+		t.Errorf("Expected MaxUploadMB to be 10, got %d", e.MaxUploadMB)
+	}
+}
+
+// TestLoadEnvironmentOverrides tests that every field can be overridden
+func TestLoadEnvironmentOverrides(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("PORT", "80")
+	t.Setenv("API_AUTH", "secret")
+	t.Setenv("STATIC_DIR", "/srv/static")
+	t.Setenv("IMAGES_DIR", "/srv/images")
+	t.Setenv("CHOICE_B_IMG_DIR", "/srv/celebs")
+	t.Setenv("QUESTION_COUNT", "8")
+	t.Setenv("OPTION_COUNT", "4")
+	t.Setenv("RESCAN_INTERVAL", "30s")
+	t.Setenv("QUESTION_TIME", "0")
+	t.Setenv("MAX_UPLOAD_MB", "25")
+
+	e, err := load(nil)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+
+	if e.Port != 80 || e.APIAuth != "secret" || e.StaticDir != "/srv/static" {
+		t.Errorf("Unexpected values: %+v", e)
+	}
+	if e.ChoiceAImgDir != "/srv/images/choice_a" {
+		t.Errorf("Expected ChoiceAImgDir under IMAGES_DIR, got %s", e.ChoiceAImgDir)
+	}
+	if e.ChoiceBImgDir != "/srv/celebs" || e.EndingImgDir != "/srv/images/ending" {
+		t.Errorf("Unexpected image directories: %s, %s", e.ChoiceBImgDir, e.EndingImgDir)
+	}
+	if e.QuestionCount != 8 || e.OptionCount != 4 || e.RescanInterval != 30*time.Second || e.QuestionTime != 0 {
+		t.Errorf("Unexpected game values: %d, %d, %s, %s", e.QuestionCount, e.OptionCount, e.RescanInterval, e.QuestionTime)
+	}
+	if e.MaxUploadMB != 25 {
+		t.Errorf("Expected MaxUploadMB to be 25, got %d", e.MaxUploadMB)
+	}
+}
+
+// TestLoadEnvironmentInvalid tests that invalid values are reported
+func TestLoadEnvironmentInvalid(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		wantErr string
+	}{
+		{"PORT", "eighty", "PORT must be an integer"},
+		{"PORT", "70000", "PORT must be between 1 and 65535"},
+		{"QUESTION_COUNT", "0", "QUESTION_COUNT must be at least 1"},
+		{"QUESTION_COUNT", "five", "QUESTION_COUNT must be an integer"},
+		{"OPTION_COUNT", "1", "OPTION_COUNT must be between 2 and 6"},
+		{"RESCAN_INTERVAL", "soon", "RESCAN_INTERVAL must be a duration"},
+		{"RESCAN_INTERVAL", "-1m", "RESCAN_INTERVAL must not be negative"},
+		{"SESSION_TTL", "0", "SESSION_TTL must be longer than 0"},
+		{"SESSION_TTL", "-12h", "SESSION_TTL must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			clearConfigEnv(t)
+			t.Setenv(tt.key, tt.value)
+
+			e, err := load(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if e.Port != 8080 || e.QuestionCount != 5 || e.RescanInterval != time.Minute {
+				t.Errorf("Expected invalid values to fall back to defaults, got %+v", e)
+			}
+		})
+	}
+}
+
+// TestApplyFile tests loading every setting from a config file
+func TestApplyFile(t *testing.T) {
+	path := writeConfigFile(t, "game.yaml", `server:
+  port: 9090
+  auth: from-file
+  static_dir: /srv/static
+images:
+  dir: /srv/images
+  ending: /srv/party
+  rescan_interval: 0
+game:
+  question_count: 3
+`)
+
+	e := defaults()
+	if errs := applyFile(e, path); len(errs) != 0 {
+		t.Fatalf("applyFile failed: %v", errs)
+	}
+	finalize(e)
+
+	if e.Port != 9090 || e.APIAuth != "from-file" || e.StaticDir != "/srv/static" {
+		t.Errorf("Unexpected server values: %+v", e)
+	}
+	if e.ChoiceAImgDir != "/srv/images/choice_a" || e.EndingImgDir != "/srv/party" {
+		t.Errorf("Unexpected image directories: %s, %s", e.ChoiceAImgDir, e.EndingImgDir)
+	}
+	if e.RescanInterval != 0 || e.QuestionCount != 3 {
+		t.Errorf("Unexpected values: %s, %d", e.RescanInterval, e.QuestionCount)
+	}
+}
+
+// TestApplyFileValidation tests that schema errors point at the right line
+func TestApplyFileValidation(t *testing.T) {
+	path := writeConfigFile(t, "game.yaml", `server:
+  prot: 9090
+  port: high
+images: ./images
+game:
+  question_count:
+    - 1
+colors:
+  pink: true
+`)
+
+	errs := applyFile(defaults(), path)
+	want := []string{
+		path + `:2: unknown key "server.prot"`,
+		path + `:3: server.port must be an integer, got "high"`,
+		path + `:4: images must be a section of settings`,
+		path + `:6: game.question_count must be a single value`,
+		path + `:8: unknown key "colors"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(want), len(errs), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != want[i] {
+			t.Errorf("Expected error %q, got %q", want[i], err.Error())
+		}
+	}
+}
+
+// TestApplyFileLists tests list settings in the config file and environment
+func TestApplyFileLists(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, "game.yaml", `server:
+  cors_origins:
+    - https://example.com
+    - https://*.party.example
+  cors_credentials: yes
+  auth:
+    - not-a-list-setting
+`)
+
+	e := defaults()
+	errs := applyFile(e, path)
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), `:5: server.cors_credentials must be true or false, got "yes"`) {
+		t.Errorf("Unexpected error: %v", errs[0])
+	}
+	if !strings.Contains(errs[1].Error(), ":6: server.auth must be a single value") {
+		t.Errorf("Unexpected error: %v", errs[1])
+	}
+	if len(e.CORSOrigins) != 2 || e.CORSOrigins[1] != "https://*.party.example" {
+		t.Errorf("Unexpected origins: %v", e.CORSOrigins)
+	}
+
+	t.Setenv("CORS_ORIGINS", " https://a.example, ,https://b.example ")
+	t.Setenv("CORS_CREDENTIALS", "true")
+	if errs := applyEnvironment(e); len(errs) != 0 {
+		t.Fatalf("applyEnvironment failed: %v", errs)
+	}
+	if len(e.CORSOrigins) != 2 || e.CORSOrigins[0] != "https://a.example" || !e.CORSCredentials {
+		t.Errorf("Unexpected CORS settings: %v, %v", e.CORSOrigins, e.CORSCredentials)
+	}
+}
+
+// TestLoadPrecedence tests that flags beat env, env beats the file and the
+// file beats the defaults
+func TestLoadPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	path := writeConfigFile(t, "game.json", `{
+  "server": {"port": 9000, "auth": "file-auth", "static_dir": "/file/static"},
+  "game": {"question_count": 9}
+}`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "9001")
+	t.Setenv("API_AUTH", "env-auth")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"-port", "9002"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := load(fs)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if e.Port != 9002 {
+		t.Errorf("Expected flag to win with port 9002, got %d", e.Port)
+	}
+	if e.APIAuth != "env-auth" {
+		t.Errorf("Expected env to beat file, got %s", e.APIAuth)
+	}
+	if e.StaticDir != "/file/static" || e.QuestionCount != 9 {
+		t.Errorf("Expected file values to beat defaults, got %s, %d", e.StaticDir, e.QuestionCount)
+	}
+	if e.ImagesDir != "./images" {
+		t.Errorf("Expected default ImagesDir, got %s", e.ImagesDir)
+	}
+}
+
+// TestLoadConfigFlag tests that -config overrides CONFIG_FILE
+func TestLoadConfigFlag(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", "/nonexistent/game.yaml")
+	path := writeConfigFile(t, "game.yaml", "server:\n  port: 7000\n")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"-config", path}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := load(fs)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if e.Port != 7000 {
+		t.Errorf("Expected port 7000 from -config file, got %d", e.Port)
+	}
+}
+
+// TestLoadMissingConfigFile tests that an explicit but missing file is an error
+func TestLoadMissingConfigFile(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("CONFIG_FILE", "/nonexistent/game.yaml")
+
+	if _, err := load(nil); err == nil {
+		t.Error("Expected error for missing config file, got nil")
+	}
+}
+
 // TestLoadDotEnv tests the loadDotEnv function
 func TestLoadDotEnv(t *testing.T) {
 	// Create a temporary .env file
@@ -252,3 +538,30 @@ TEST_VAR=test_value
 		loadDotEnv(envFile)
 	}
 }
+
+// TestOriginsField tests the validation of allowed origins
+func TestOriginsField(t *testing.T) {
+	tests := []struct {
+		val   string
+		want  []string
+		error bool
+	}{
+		{"https://Example.com/, *", []string{"https://example.com", "*"}, false},
+		{"https://*.example.com, http://localhost:3000", []string{"https://*.example.com", "http://localhost:3000"}, false},
+		{"example.com", nil, true},
+		{"ftp://example.com", nil, true},
+		{"https://example.com/game", nil, true},
+	}
+
+	for _, tt := range tests {
+		e := defaults()
+		err := originsField(e, tt.val)
+		if (err != nil) != tt.error {
+			t.Errorf("originsField(%q): unexpected error %v", tt.val, err)
+			continue
+		}
+		if !tt.error && strings.Join(e.CORSOrigins, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("originsField(%q): expected %v, got %v", tt.val, tt.want, e.CORSOrigins)
+		}
+	}
+}
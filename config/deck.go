@@ -0,0 +1,223 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultDeck is the name of the deck built from the top-level settings
+const DefaultDeck = "default"
+
+// MaxOptionCount limits the images shown per question to what fits a screen
+const MaxOptionCount = 6
+
+// Deck is a named game with its own images, question and option counts,
+// password and frontend texts
+type Deck struct {
+	Name          string
+	APIAuth       string
+	ChoiceAImgDir string
+	ChoiceBImgDir string
+	EndingImgDir  string
+	QuestionCount int
+	OptionCount   int
+	// Manifest is an optional JSON file of curated questions; it is not
+	// inherited by named decks
+	Manifest string
+	// QuestionTime is how long a question of a speed game may take; 0
+	// disables speed games
+	QuestionTime time.Duration
+	Texts        DeckTexts
+
+	questionTimeSet bool // QuestionTime is configured, so 0 is not inherited
+}
+
+// DeckTexts overrides the texts of the frontend config for a deck. Empty
+// values keep the frontend's own config.
+type DeckTexts struct {
+	Title         string   `json:"title,omitempty"`
+	MadeBy        string   `json:"madeBy,omitempty"`
+	SpecialPerson string   `json:"specialPerson,omitempty"`
+	SpecialDay    string   `json:"specialDay,omitempty"`
+	WishLines     []string `json:"wishLines,omitempty"`
+	LoadingTexts  []string `json:"loadingTexts,omitempty"`
+}
+
+// deckField describes one deck setting in the config file. Scalar settings
+// use set, list settings use setList.
+type deckField struct {
+	key     string
+	set     func(d *Deck, val string) error
+	setList func(d *Deck, vals []string)
+}
+
+var deckFields = []deckField{
+	{key: "auth", set: func(d *Deck, val string) error { d.APIAuth = val; return nil }},
+	{key: "choice_a", set: func(d *Deck, val string) error { d.ChoiceAImgDir = val; return nil }},
+	{key: "choice_b", set: func(d *Deck, val string) error { d.ChoiceBImgDir = val; return nil }},
+	{key: "ending", set: func(d *Deck, val string) error { d.EndingImgDir = val; return nil }},
+	{key: "question_count", set: func(d *Deck, val string) (err error) {
+		d.QuestionCount, err = parseInt(val, 1, 0)
+		return err
+	}},
+	{key: "option_count", set: func(d *Deck, val string) (err error) {
+		d.OptionCount, err = parseInt(val, 2, MaxOptionCount)
+		return err
+	}},
+	{key: "manifest", set: func(d *Deck, val string) error { d.Manifest = val; return nil }},
+	{key: "question_time", set: func(d *Deck, val string) (err error) {
+		d.QuestionTime, err = parseDuration(val)
+		d.questionTimeSet = err == nil
+		return err
+	}},
+	{key: "texts.title", set: func(d *Deck, val string) error { d.Texts.Title = val; return nil }},
+	{key: "texts.made_by", set: func(d *Deck, val string) error { d.Texts.MadeBy = val; return nil }},
+	{key: "texts.special_person", set: func(d *Deck, val string) error { d.Texts.SpecialPerson = val; return nil }},
+	{key: "texts.special_day", set: func(d *Deck, val string) error { d.Texts.SpecialDay = val; return nil }},
+	{key: "texts.wish_lines", setList: func(d *Deck, vals []string) { d.Texts.WishLines = vals }},
+	{key: "texts.loading_texts", setList: func(d *Deck, vals []string) { d.Texts.LoadingTexts = vals }},
+}
+
+var deckNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Deck returns the deck with the given name
+func (e *env) Deck(name string) (*Deck, bool) {
+	for _, d := range e.Decks {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// applyDecks reads the "decks" section of the config file, a map from deck
+// name to deck settings
+func applyDecks(e *env, path string, decks *node) []error {
+	if decks.Kind != mapNode {
+		return []error{fmt.Errorf("%s:%d: decks must be a map of deck names to settings", path, decks.Line)}
+	}
+
+	var errs []error
+	for _, name := range decks.Keys {
+		n := decks.Map[name]
+		if !deckNamePattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("%s:%d: deck name %q must use lowercase letters, digits, '-' and '_'", path, n.Line, name))
+			continue
+		}
+		if _, dup := e.Deck(name); dup {
+			errs = append(errs, fmt.Errorf("%s:%d: deck %q is already defined", path, n.Line, name))
+			continue
+		}
+		d := &Deck{Name: name}
+		switch {
+		case n.Kind == scalarNode && n.Value == "":
+			// "name:" alone declares a deck with default settings
+		case n.Kind == mapNode:
+			errs = append(errs, applyDeckNode(d, path, "decks."+name, n)...)
+		default:
+			errs = append(errs, fmt.Errorf("%s:%d: deck %q must be a section of settings", path, n.Line, name))
+			continue
+		}
+		e.Decks = append(e.Decks, d)
+	}
+	return errs
+}
+
+// applyDeckNode sets the deck settings found below prefix
+func applyDeckNode(d *Deck, path, prefix string, n *node) []error {
+	known := make(map[string]deckField, len(deckFields))
+	sections := make(map[string]bool)
+	for _, f := range deckFields {
+		known[f.key] = f
+		if section, _, ok := strings.Cut(f.key, "."); ok {
+			sections[section] = true
+		}
+	}
+
+	var errs []error
+	var walk func(sub string, n *node)
+	walk = func(sub string, n *node) {
+		for _, key := range n.Keys {
+			child := n.Map[key]
+			rel := key
+			if sub != "" {
+				rel = sub + "." + key
+			}
+			dotted := prefix + "." + rel
+
+			f, ok := known[rel]
+			switch {
+			case ok && f.setList != nil:
+				if child.Kind != listNode {
+					errs = append(errs, fmt.Errorf("%s:%d: %s must be a list", path, child.Line, dotted))
+					continue
+				}
+				vals := make([]string, 0, len(child.List))
+				for _, item := range child.List {
+					vals = append(vals, item.Value)
+				}
+				f.setList(d, vals)
+			case ok:
+				if child.Kind != scalarNode {
+					errs = append(errs, fmt.Errorf("%s:%d: %s must be a single value", path, child.Line, dotted))
+				} else if err := f.set(d, child.Value); err != nil {
+					errs = append(errs, fmt.Errorf("%s:%d: %s %w", path, child.Line, dotted, err))
+				}
+			case sections[rel]:
+				if child.Kind != mapNode {
+					errs = append(errs, fmt.Errorf("%s:%d: %s must be a section of settings", path, child.Line, dotted))
+					continue
+				}
+				walk(rel, child)
+			default:
+				errs = append(errs, fmt.Errorf("%s:%d: unknown key %q", path, child.Line, dotted))
+			}
+		}
+	}
+	walk("", n)
+	return errs
+}
+
+// finalizeDecks makes sure the default deck exists and fills unset deck
+// settings from the top-level ones. Image directories of named decks
+// default to <ImagesDir>/<name>/choice_a and so on.
+func finalizeDecks(e *env) {
+	if _, ok := e.Deck(DefaultDeck); !ok {
+		e.Decks = append([]*Deck{{Name: DefaultDeck}}, e.Decks...)
+	}
+
+	for _, d := range e.Decks {
+		if d.APIAuth == "" {
+			d.APIAuth = e.APIAuth
+		}
+		if d.QuestionCount == 0 {
+			d.QuestionCount = e.QuestionCount
+		}
+		if d.OptionCount == 0 {
+			d.OptionCount = e.OptionCount
+		}
+		if !d.questionTimeSet {
+			d.QuestionTime = e.QuestionTime
+		}
+		base := filepath.Join(e.ImagesDir, d.Name)
+		choiceA, choiceB, ending := filepath.Join(base, "choice_a"), filepath.Join(base, "choice_b"), filepath.Join(base, "ending")
+		if d.Name == DefaultDeck {
+			choiceA, choiceB, ending = e.ChoiceAImgDir, e.ChoiceBImgDir, e.EndingImgDir
+			if d.Manifest == "" {
+				d.Manifest = e.Manifest
+			}
+		}
+		if d.ChoiceAImgDir == "" {
+			d.ChoiceAImgDir = choiceA
+		}
+		if d.ChoiceBImgDir == "" {
+			d.ChoiceBImgDir = choiceB
+		}
+		if d.EndingImgDir == "" {
+			d.EndingImgDir = ending
+		}
+	}
+}
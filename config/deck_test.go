@@ -0,0 +1,130 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestApplyDecks tests loading decks from a config file
+func TestApplyDecks(t *testing.T) {
+	path := writeConfigFile(t, "game.yaml", `server:
+  auth: global
+images:
+  dir: /srv/images
+game:
+  manifest: /srv/questions.json
+decks:
+  alice:
+    auth: alice-pw
+    question_count: 3
+    option_count: 4
+    manifest: /srv/alice.json
+    question_time: 8s
+    choice_b: /srv/celebs
+    texts:
+      title: Alice's game
+      wish_lines:
+        - Happy birthday!
+        - Cheers
+  bob:
+  carol:
+    question_time: 0
+`)
+
+	e := defaults()
+	if errs := applyFile(e, path); len(errs) != 0 {
+		t.Fatalf("applyFile failed: %v", errs)
+	}
+	finalize(e)
+
+	if len(e.Decks) != 4 || e.Decks[0].Name != DefaultDeck {
+		t.Fatalf("Expected default deck first plus three decks, got %d decks", len(e.Decks))
+	}
+
+	alice, ok := e.Deck("alice")
+	if !ok {
+		t.Fatal("Expected deck alice")
+	}
+	if alice.APIAuth != "alice-pw" || alice.QuestionCount != 3 || alice.OptionCount != 4 {
+		t.Errorf("Unexpected alice settings: %+v", alice)
+	}
+	if alice.Manifest != "/srv/alice.json" || alice.QuestionTime != 8*time.Second {
+		t.Errorf("Unexpected alice manifest or question time: %q, %s", alice.Manifest, alice.QuestionTime)
+	}
+	if alice.ChoiceAImgDir != "/srv/images/alice/choice_a" || alice.ChoiceBImgDir != "/srv/celebs" {
+		t.Errorf("Unexpected alice directories: %s, %s", alice.ChoiceAImgDir, alice.ChoiceBImgDir)
+	}
+	if alice.Texts.Title != "Alice's game" || len(alice.Texts.WishLines) != 2 {
+		t.Errorf("Unexpected alice texts: %+v", alice.Texts)
+	}
+
+	bob, _ := e.Deck("bob")
+	if bob.APIAuth != "global" || bob.QuestionCount != 5 || bob.OptionCount != 2 || bob.QuestionTime != 15*time.Second || bob.Manifest != "" || bob.EndingImgDir != "/srv/images/bob/ending" {
+		t.Errorf("Expected bob to inherit the top-level settings, got %+v", bob)
+	}
+
+	// An explicit 0 turns speed games off rather than inheriting the default
+	carol, _ := e.Deck("carol")
+	if carol.QuestionTime != 0 || carol.QuestionCount != 5 {
+		t.Errorf("Expected carol without speed games, got %+v", carol)
+	}
+
+	def, _ := e.Deck(DefaultDeck)
+	if def.ChoiceAImgDir != "/srv/images/choice_a" || def.APIAuth != "global" || def.Manifest != "/srv/questions.json" {
+		t.Errorf("Expected default deck from top-level settings, got %+v", def)
+	}
+}
+
+// TestApplyDecksDefaultOverride tests customizing the default deck
+func TestApplyDecksDefaultOverride(t *testing.T) {
+	path := writeConfigFile(t, "game.yaml", `decks:
+  default:
+    texts:
+      made_by: Eann
+`)
+
+	e := defaults()
+	if errs := applyFile(e, path); len(errs) != 0 {
+		t.Fatalf("applyFile failed: %v", errs)
+	}
+	finalize(e)
+
+	if len(e.Decks) != 1 {
+		t.Fatalf("Expected a single deck, got %d", len(e.Decks))
+	}
+	if e.Decks[0].Texts.MadeBy != "Eann" || e.Decks[0].ChoiceAImgDir != e.ChoiceAImgDir {
+		t.Errorf("Unexpected default deck: %+v", e.Decks[0])
+	}
+}
+
+// TestApplyDecksValidation tests deck errors with line numbers
+func TestApplyDecksValidation(t *testing.T) {
+	path := writeConfigFile(t, "game.yaml", `decks:
+  Alice:
+    auth: x
+  bob:
+    question_count: 0
+    colour: pink
+    texts:
+      wish_lines: single
+  carol: nope
+`)
+
+	errs := applyFile(defaults(), path)
+	want := []string{
+		`:2: deck name "Alice" must use lowercase letters`,
+		`:5: decks.bob.question_count must be at least 1, got 0`,
+		`:6: unknown key "decks.bob.colour"`,
+		`:8: decks.bob.texts.wish_lines must be a list`,
+		`:9: deck "carol" must be a section of settings`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(want), len(errs), errs)
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), path+want[i]) {
+			t.Errorf("Expected error containing %q, got %q", want[i], err.Error())
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type nodeKind int
+
+const (
+	scalarNode nodeKind = iota
+	mapNode
+	listNode
+)
+
+// node is a parsed config file value along with the line it was found on
+type node struct {
+	Line  int
+	Kind  nodeKind
+	Value string           // scalarNode
+	Keys  []string         // mapNode, in file order
+	Map   map[string]*node // mapNode
+	List  []*node          // listNode
+}
+
+// lineError is a parse or validation error tied to a line of a config file
+type lineError struct {
+	line int
+	msg  string
+}
+
+func (e *lineError) Error() string {
+	return fmt.Sprintf("%d: %s", e.line, e.msg)
+}
+
+func errorAt(line int, format string, args ...any) error {
+	return &lineError{line: line, msg: fmt.Sprintf(format, args...)}
+}
+
+func newMapNode(line int) *node {
+	return &node{Line: line, Kind: mapNode, Map: make(map[string]*node)}
+}
+
+// set adds key to a map node, rejecting duplicates
+func (n *node) set(key string, child *node) error {
+	if prev, ok := n.Map[key]; ok {
+		return errorAt(child.Line, "duplicate key %q (first defined on line %d)", key, prev.Line)
+	}
+	n.Keys = append(n.Keys, key)
+	n.Map[key] = child
+	return nil
+}
+
+// parseFile reads a JSON or YAML config file. The format is chosen by the
+// file extension, falling back to JSON when the content starts with '{'.
+func parseFile(path string) (*node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root *node
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".json", ext != ".yaml" && ext != ".yml" && bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		root, err = parseJSON(data)
+	default:
+		root, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	if root.Kind != mapNode {
+		return nil, fmt.Errorf("%s:%d: top level must be a map of settings", path, root.Line)
+	}
+	return root, nil
+}
+
+// parseJSON decodes data into a node tree, keeping track of line numbers
+func parseJSON(data []byte) (*node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:min(offset, int64(len(data)))], []byte("\n")) + 1
+	}
+
+	root, err := parseJSONValue(dec, lineAt)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, errorAt(lineAt(syntaxErr.Offset), "%v", syntaxErr)
+		}
+		var lineErr *lineError
+		if errors.As(err, &lineErr) {
+			return nil, err
+		}
+		return nil, errorAt(lineAt(dec.InputOffset()), "%v", err)
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, errorAt(lineAt(dec.InputOffset()), "unexpected data after top-level value")
+	}
+	return root, nil
+}
+
+func parseJSONValue(dec *json.Decoder, lineAt func(int64) int) (*node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	line := lineAt(dec.InputOffset())
+
+	switch v := tok.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			n := newMapNode(line)
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ := keyTok.(string)
+				keyLine := lineAt(dec.InputOffset())
+				child, err := parseJSONValue(dec, lineAt)
+				if err != nil {
+					return nil, err
+				}
+				child.Line = keyLine
+				if err := n.set(key, child); err != nil {
+					return nil, err
+				}
+			}
+			_, err := dec.Token() // closing '}'
+			return n, err
+		case '[':
+			n := &node{Line: line, Kind: listNode}
+			for dec.More() {
+				child, err := parseJSONValue(dec, lineAt)
+				if err != nil {
+					return nil, err
+				}
+				n.List = append(n.List, child)
+			}
+			_, err := dec.Token() // closing ']'
+			return n, err
+		}
+	case string:
+		return &node{Line: line, Value: v}, nil
+	case json.Number:
+		return &node{Line: line, Value: v.String()}, nil
+	case bool:
+		return &node{Line: line, Value: strconv.FormatBool(v)}, nil
+	case nil:
+		return &node{Line: line}, nil
+	}
+	return nil, errorAt(line, "unexpected token %v", tok)
+}
+
+// yamlLine is a significant (non-blank, non-comment) line of a YAML file
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// parseYAML parses the subset of YAML used by config files: nested maps
+// of "key: value" pairs, lists of scalars written as "- value" and quoted
+// or plain scalars. Anchors, flow collections and multi-line strings are
+// not supported.
+func parseYAML(data []byte) (*node, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \r")
+		trimmed := strings.TrimLeft(raw, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, errorAt(i+1, "tabs are not allowed for indentation")
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(raw) - len(trimmed), text: stripComment(trimmed)})
+	}
+	if len(lines) == 0 {
+		return newMapNode(1), nil
+	}
+
+	p := &yamlParser{lines: lines}
+	root, err := p.parseBlock(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, errorAt(p.lines[p.pos].num, "unexpected indentation")
+	}
+	return root, nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseBlock parses consecutive lines at the given indentation as either a
+// list or a map, depending on the first line
+func (p *yamlParser) parseBlock(indent int) (*node, error) {
+	first := p.lines[p.pos]
+	if first.text == "-" || strings.HasPrefix(first.text, "- ") {
+		return p.parseList(indent)
+	}
+	return p.parseMap(indent)
+}
+
+func (p *yamlParser) parseList(indent int) (*node, error) {
+	n := &node{Line: p.lines[p.pos].num, Kind: listNode}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, errorAt(l.num, "unexpected indentation")
+		}
+		if l.text != "-" && !strings.HasPrefix(l.text, "- ") {
+			return nil, errorAt(l.num, "expected a list item starting with \"- \"")
+		}
+		item := strings.TrimSpace(strings.TrimPrefix(l.text, "-"))
+		if _, _, isPair := splitKey(item); isPair {
+			return nil, errorAt(l.num, "maps inside lists are not supported")
+		}
+		val, err := unquote(item)
+		if err != nil {
+			return nil, errorAt(l.num, "%v", err)
+		}
+		n.List = append(n.List, &node{Line: l.num, Value: val})
+		p.pos++
+	}
+	return n, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (*node, error) {
+	n := newMapNode(p.lines[p.pos].num)
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, errorAt(l.num, "unexpected indentation")
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, errorAt(l.num, "expected \"key: value\"")
+		}
+		p.pos++
+
+		var child *node
+		if rest != "" {
+			val, err := unquote(rest)
+			if err != nil {
+				return nil, errorAt(l.num, "%v", err)
+			}
+			child = &node{Line: l.num, Value: val}
+		} else if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+			var err error
+			if child, err = p.parseBlock(p.lines[p.pos].indent); err != nil {
+				return nil, err
+			}
+			child.Line = l.num
+		} else if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && strings.HasPrefix(p.lines[p.pos].text, "- ") {
+			// A list may sit at the same indentation as its key
+			var err error
+			if child, err = p.parseList(indent); err != nil {
+				return nil, err
+			}
+			child.Line = l.num
+		} else {
+			child = &node{Line: l.num}
+		}
+
+		if err := n.set(key, child); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// splitKey splits "key: value" into its parts
+func splitKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		return "", "", false
+	}
+	key, rest, ok := strings.Cut(text, ":")
+	if !ok || (rest != "" && rest[0] != ' ') {
+		return "", "", false
+	}
+	key = strings.TrimSpace(key)
+	return key, strings.TrimSpace(rest), key != ""
+}
+
+// stripComment removes a trailing " # comment" outside of quotes
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && i > 0 && text[i-1] == ' ':
+			return strings.TrimRight(text[:i], " ")
+		}
+	}
+	return text
+}
+
+// unquote returns the value of a plain, single- or double-quoted scalar
+func unquote(val string) (string, error) {
+	switch {
+	case strings.HasPrefix(val, `"`):
+		s, err := strconv.Unquote(val)
+		if err != nil {
+			return "", fmt.Errorf("invalid double-quoted string %s", val)
+		}
+		return s, nil
+	case strings.HasPrefix(val, "'"):
+		if len(val) < 2 || !strings.HasSuffix(val, "'") {
+			return "", fmt.Errorf("invalid single-quoted string %s", val)
+		}
+		return strings.ReplaceAll(val[1:len(val)-1], "''", "'"), nil
+	case strings.HasPrefix(val, "[") || strings.HasPrefix(val, "{"):
+		return "", fmt.Errorf("flow collections are not supported: %s", val)
+	}
+	return val, nil
+}
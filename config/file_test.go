@@ -0,0 +1,174 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes content to a file with the given name in a temp dir
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestParseYAML tests parsing of the supported YAML subset
+func TestParseYAML(t *testing.T) {
+	content := `# game setup
+server:
+  port: 9000   # trailing comment
+  auth: "pa#ss word"
+game:
+  question_count: '7'
+tags:
+  - one
+  - "two"
+empty:
+`
+	root, err := parseYAML([]byte(content))
+	if err != nil {
+		t.Fatalf("parseYAML failed: %v", err)
+	}
+
+	server := root.Map["server"]
+	if server == nil || server.Kind != mapNode || server.Line != 2 {
+		t.Fatalf("Unexpected server node: %+v", server)
+	}
+	if port := server.Map["port"]; port.Value != "9000" || port.Line != 3 {
+		t.Errorf("Unexpected port node: %+v", port)
+	}
+	if auth := server.Map["auth"]; auth.Value != "pa#ss word" {
+		t.Errorf("Expected quoted value with '#', got %q", auth.Value)
+	}
+	if count := root.Map["game"].Map["question_count"]; count.Value != "7" {
+		t.Errorf("Expected single-quoted value 7, got %q", count.Value)
+	}
+	tags := root.Map["tags"]
+	if tags.Kind != listNode || len(tags.List) != 2 || tags.List[1].Value != "two" {
+		t.Errorf("Unexpected list node: %+v", tags)
+	}
+	if empty := root.Map["empty"]; empty.Kind != scalarNode || empty.Value != "" {
+		t.Errorf("Expected empty scalar, got %+v", empty)
+	}
+	if strings.Join(root.Keys, ",") != "server,game,tags,empty" {
+		t.Errorf("Expected keys in file order, got %v", root.Keys)
+	}
+}
+
+// TestParseYAMLErrors tests that YAML errors carry the offending line
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"bad indentation", "server:\n  port: 1\n    auth: x\n", "3: unexpected indentation"},
+		{"missing colon", "server:\n  port 1\n", "2: expected \"key: value\""},
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", "3: duplicate key \"a\" (first defined on line 1)"},
+		{"tab indentation", "server:\n\tport: 1\n", "2: tabs are not allowed"},
+		{"map in list", "list:\n  - a: b\n", "2: maps inside lists are not supported"},
+		{"flow list", "list: [a, b]\n", "1: flow collections are not supported"},
+		{"bad quote", "a: \"open\n", "1: invalid double-quoted string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestParseJSON tests parsing JSON with line numbers
+func TestParseJSON(t *testing.T) {
+	content := `{
+  "server": {
+    "port": 9000,
+    "auth": "secret"
+  },
+  "game": {"question_count": 3},
+  "flags": [true, null]
+}`
+	root, err := parseJSON([]byte(content))
+	if err != nil {
+		t.Fatalf("parseJSON failed: %v", err)
+	}
+
+	if port := root.Map["server"].Map["port"]; port.Value != "9000" || port.Line != 3 {
+		t.Errorf("Unexpected port node: %+v", port)
+	}
+	if auth := root.Map["server"].Map["auth"]; auth.Value != "secret" || auth.Line != 4 {
+		t.Errorf("Unexpected auth node: %+v", auth)
+	}
+	if count := root.Map["game"].Map["question_count"]; count.Line != 6 {
+		t.Errorf("Expected question_count on line 6, got %d", count.Line)
+	}
+	if flags := root.Map["flags"]; len(flags.List) != 2 || flags.List[0].Value != "true" {
+		t.Errorf("Unexpected list node: %+v", flags)
+	}
+}
+
+// TestParseJSONErrors tests that JSON errors carry the offending line
+func TestParseJSONErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"syntax error", "{\n  \"a\": 1,\n  \"b\" 2\n}", "3: "},
+		{"duplicate key", "{\n  \"a\": 1,\n  \"a\": 2\n}", "3: duplicate key \"a\""},
+		{"trailing data", "{}\n{}", "unexpected data after top-level value"},
+		{"truncated", "{\n  \"a\": 1,\n", "3: unexpected end of JSON input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJSON([]byte(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestParseFileFormats tests that the format is picked by extension or content
+func TestParseFileFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"game.yaml", "server:\n  port: 81\n"},
+		{"game.yml", "server:\n  port: 81\n"},
+		{"game.json", `{"server": {"port": 81}}`},
+		{"game.conf", `{"server": {"port": 81}}`},
+		{"game.conf", "server:\n  port: 81\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseFile(writeConfigFile(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatalf("parseFile failed: %v", err)
+			}
+			if got := root.Map["server"].Map["port"].Value; got != "81" {
+				t.Errorf("Expected port 81, got %q", got)
+			}
+		})
+	}
+}
+
+// TestParseFileTopLevelList tests that a config file must describe a map
+func TestParseFileTopLevelList(t *testing.T) {
+	path := writeConfigFile(t, "game.yaml", "- a\n- b\n")
+	_, err := parseFile(path)
+	if err == nil || !strings.Contains(err.Error(), path+":1: top level must be a map") {
+		t.Errorf("Expected top level error, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// consoleFiles is the admin console, built into the binary so that it is
+// never served from the public StaticDir
+//
+//go:embed console
+var consoleFiles embed.FS
+
+// AdminDeck describes a deck in the admin console
+type AdminDeck struct {
+	Name           string    `json:"name"`
+	Title          string    `json:"title,omitempty"`
+	QuestionCount  int       `json:"questionCount"`
+	OptionCount    int       `json:"optionCount"`
+	QuestionTimeMs int64     `json:"questionTimeMs"`
+	Curated        bool      `json:"curated"` // questions come from a manifest
+	ChoiceA        int       `json:"choiceA"`
+	ChoiceB        int       `json:"choiceB"`
+	Ending         int       `json:"ending"`
+	ScannedAt      time.Time `json:"scannedAt"`
+	// PasswordRotatedAt is missing while the configured password is in use
+	PasswordRotatedAt *time.Time `json:"passwordRotatedAt,omitempty"`
+}
+
+// ImageRef names an image file of a deck like the admin image API does
+type ImageRef struct {
+	Dir  string `json:"dir"`
+	Name string `json:"name"`
+}
+
+// PreviewQuestion is a question as a game of the previewed seed asks it
+type PreviewQuestion struct {
+	Caption string     `json:"caption,omitempty"`
+	Options []ImageRef `json:"options"`
+	Correct int        `json:"correct"`
+}
+
+// AdminPreview is the game a seed produces for a player without history
+type AdminPreview struct {
+	Deck      string            `json:"deck"`
+	Seed      int64             `json:"seed"`
+	Questions []PreviewQuestion `json:"questions"`
+}
+
+// PasswordRotation sets a new game password for a deck
+type PasswordRotation struct {
+	Password string `json:"password"`
+	SignOut  bool   `json:"signOut"` // end the sessions of everyone logged in
+}
+
+// consoleHandler serves the admin console under /admin/
+func consoleHandler() http.Handler {
+	files, err := fs.Sub(consoleFiles, "console")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/admin/", http.FileServer(http.FS(files)))
+}
+
+// adminDecksHandler lists every deck with the number of its images
+func adminDecksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	decks := make([]AdminDeck, 0, len(config.Env().Decks))
+	for _, deck := range config.Env().Decks {
+		d := AdminDeck{
+			Name:           deck.Name,
+			Title:          deck.Texts.Title,
+			QuestionCount:  deck.QuestionCount,
+			OptionCount:    deck.OptionCount,
+			QuestionTimeMs: deck.QuestionTime.Milliseconds(),
+			Curated:        deck.Manifest != "",
+		}
+		if c, ok := catalogs[deck.Name]; ok {
+			images := c.Snapshot()
+			d.ChoiceA, d.ChoiceB, d.Ending = len(images.ChoiceA), len(images.ChoiceB), len(images.Ending)
+			d.ScannedAt = images.ScannedAt
+		}
+		if rotated := passwords.rotatedAt(deck.Name); !rotated.IsZero() {
+			d.PasswordRotatedAt = &rotated
+		}
+		decks = append(decks, d)
+	}
+	writeAdminJSON(w, http.StatusOK, decks)
+}
+
+// adminPreviewHandler shows the questions generateQuestions produces for
+// the deck given with ?deck= from ?seed=, or a new seed, at the
+// ?difficulty= of the game. Players who have seen images of the deck may
+// be asked different ones.
+func adminPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deck := deckOf(r)
+	c, ok := catalogs[deck.Name]
+	if !ok {
+		respondWithError(w, "Deck has no images", fmt.Errorf("no catalog for deck %s", deck.Name))
+		return
+	}
+	seed, err := parseSeed(r.URL.Query().Get("seed"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	difficulty, err := parseDifficulty(r.URL.Query().Get("difficulty"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	images := c.Snapshot()
+	images.Weight = stats.weight(deck.Name, difficulty)
+	questions, answers, err := buildQuestions(newRand(seed), images, deck.QuestionCount, deck.OptionCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	preview := AdminPreview{Deck: deck.Name, Seed: seed, Questions: make([]PreviewQuestion, len(questions))}
+	for i, q := range questions {
+		options := make([]ImageRef, len(q.Options))
+		for j, img := range q.Options {
+			options[j] = locateImage(c, img)
+		}
+		preview.Questions[i] = PreviewQuestion{Caption: q.Caption, Options: options, Correct: answers[i].Correct}
+	}
+	writeAdminJSON(w, http.StatusOK, preview)
+}
+
+// adminImageFileHandler serves the image ?name= from ?dir= of a deck, so
+// that the console can show images without a game. ?w= resizes it like
+// the game images.
+func adminImageFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodHead}, ", "))
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deck := deckOf(r).Name
+	c, ok := catalogs[deck]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := resolveImage(c, r.URL.Query().Get("dir"), r.URL.Query().Get("name"))
+	if err != nil {
+		adminImageError(w, err)
+		return
+	}
+	http.ServeFile(w, r, variants.path(p, parseWidth(r.URL.Query().Get("w"))))
+}
+
+// adminPasswordHandler rotates the game password of the deck given with
+// ?deck=. The new password is saved in the data directory and wins over
+// the configured one from then on.
+func adminPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isJSON(r) {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req PasswordRotation
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Password) == "" {
+		http.Error(w, "password must not be empty", http.StatusBadRequest)
+		return
+	}
+	deck := deckOf(r).Name
+	if err := passwords.rotate(deck, req.Password, req.SignOut); err != nil {
+		respondWithError(w, "Could not save password", err)
+		return
+	}
+	log.Printf("Rotated the password of deck %s (signed out: %t)\n", deck, req.SignOut)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,271 @@
+// Admin console: browse decks, preview games, read statistics and rotate
+// the game password. The browser sends the admin basic authentication.
+
+const Console = {
+    elements: {},
+    /** @type {{name: string, title?: string, questionCount: number, optionCount: number, questionTimeMs: number, curated: boolean, choiceA: number, choiceB: number, ending: number, scannedAt: string, passwordRotatedAt?: string}[]} */
+    decks: [],
+    deck: '',
+
+    async init() {
+        this.cacheElements();
+        this.bindEvents();
+        try {
+            this.decks = await this.request('decks');
+        } catch (error) {
+            this.showError(error);
+            return;
+        }
+        this.elements.deckSelect.replaceChildren(...this.decks.map(deck => new Option(deck.title ? `${deck.name} · ${deck.title}` : deck.name, deck.name)));
+        this.selectDeck(this.decks[0].name);
+    },
+
+    cacheElements() {
+        const byId = id => document.getElementById(id);
+        this.elements = {
+            deckSelect: byId('deck-select'),
+            errorMessage: byId('error-message'),
+            tabs: document.querySelectorAll('[data-tab]'),
+            // Deck Tab
+            deckSummary: byId('deck-summary'),
+            uploadForm: byId('upload-form'),
+            uploadFiles: byId('upload-files'),
+            uploadDir: byId('upload-dir'),
+            deckImages: byId('deck-images'),
+            // Preview Tab
+            previewForm: byId('preview-form'),
+            previewSeed: byId('preview-seed'),
+            previewDifficulty: byId('preview-difficulty'),
+            previewSeedUsed: byId('preview-seed-used'),
+            previewQuestions: byId('preview-questions'),
+            // Statistics Tab
+            statsCsv: byId('stats-csv'),
+            statsRows: byId('stats-rows'),
+            // Password Tab
+            passwordStatus: byId('password-status'),
+            passwordForm: byId('password-form'),
+            passwordInput: byId('password-input'),
+            passwordGenerate: byId('password-generate'),
+            passwordSignOut: byId('password-sign-out')
+        };
+    },
+
+    bindEvents() {
+        this.elements.deckSelect.addEventListener('change', () => this.selectDeck(this.elements.deckSelect.value));
+        this.elements.tabs.forEach(tab => tab.addEventListener('click', () => this.showTab(tab.dataset.tab)));
+        this.elements.uploadForm.addEventListener('submit', e => { e.preventDefault(); this.upload(); });
+        this.elements.previewForm.addEventListener('submit', e => { e.preventDefault(); this.preview(); });
+        this.elements.passwordForm.addEventListener('submit', e => { e.preventDefault(); this.rotatePassword(); });
+        this.elements.passwordGenerate.addEventListener('click', () => this.elements.passwordInput.value = generatePassword());
+    },
+
+    // Sends a request to an admin endpoint of the selected deck
+    async request(path, params = {}, options = {}) {
+        const search = new URLSearchParams({ deck: this.deck, ...params });
+        const response = await fetch(`${path}?${search}`, options);
+        if (!response.ok) throw new Error((await response.text()).trim() || `Request failed with ${response.status}`);
+        return response.status === 204 ? null : await response.json();
+    },
+
+    // Thumbnails use the smallest resized variant
+    imageUrl(image) {
+        return 'image?' + new URLSearchParams({ deck: this.deck, dir: image.dir, name: image.name, w: 400 });
+    },
+
+    showError(error) {
+        this.elements.errorMessage.textContent = error ? error.message : '';
+    },
+
+    showTab(id) {
+        this.elements.tabs.forEach(tab => {
+            tab.classList.toggle('active', tab.dataset.tab === id);
+            document.getElementById(tab.dataset.tab).classList.toggle('d-none', tab.dataset.tab !== id);
+        });
+        if (id === 'stats-tab') this.loadStats();
+    },
+
+    selectDeck(name) {
+        this.deck = name;
+        this.elements.deckSelect.value = name;
+        this.elements.previewQuestions.replaceChildren();
+        this.elements.previewSeedUsed.textContent = '';
+        this.showDeck();
+        this.loadImages();
+        if (!document.getElementById('stats-tab').classList.contains('d-none')) this.loadStats();
+    },
+
+    showDeck() {
+        const deck = this.decks.find(d => d.name === this.deck);
+        const speed = deck.questionTimeMs ? `${deck.questionTimeMs / 1000}s per speed question` : 'no speed games';
+        const curated = deck.curated ? ' · curated questions' : '';
+        this.elements.deckSummary.textContent = `${deck.questionCount} questions of ${deck.optionCount} images · ${speed}${curated} · ` +
+            `${deck.choiceA} correct, ${deck.choiceB} wrong and ${deck.ending} ending images`;
+        this.elements.passwordStatus.textContent = deck.passwordRotatedAt
+            ? `The password was last rotated ${new Date(deck.passwordRotatedAt).toLocaleString()}.`
+            : 'The configured password is in use.';
+    },
+
+    async refreshDecks() {
+        this.decks = await this.request('decks');
+        this.showDeck();
+    },
+
+    async loadImages() {
+        try {
+            /** @type {{deck: string, images: {dir: string, name: string, size: number, modTime: string}[]}} */
+            const list = await this.request('images');
+            const byDir = {};
+            list.images.forEach(image => (byDir[image.dir] ||= []).push(image));
+            this.elements.deckImages.replaceChildren(...['choice_a', 'choice_b', 'ending'].map(dir => {
+                const section = document.createElement('div');
+                const heading = document.createElement('h5');
+                const images = byDir[dir] || [];
+                heading.textContent = `${dir} (${images.length})`;
+                const grid = document.createElement('div');
+                grid.className = 'd-flex flex-wrap gap-3 mb-4';
+                grid.append(...images.map(image => this.imageCard(image)));
+                section.append(heading, grid);
+                return section;
+            }));
+            this.showError();
+        } catch (error) {
+            this.showError(error);
+        }
+    },
+
+    imageCard(image) {
+        const figure = document.createElement('figure');
+        figure.className = 'text-center small m-0';
+        const img = document.createElement('img');
+        img.className = 'thumb d-block mb-1';
+        img.loading = 'lazy';
+        img.src = this.imageUrl(image);
+        const caption = document.createElement('figcaption');
+        caption.textContent = image.name;
+        const move = document.createElement('select');
+        move.className = 'form-select form-select-sm mt-1';
+        move.append(new Option('Move to…', ''), ...['choice_a', 'choice_b', 'ending'].filter(dir => dir !== image.dir).map(dir => new Option(dir, dir)));
+        move.addEventListener('change', () => move.value && this.move(image, move.value));
+        const remove = document.createElement('button');
+        remove.className = 'btn btn-outline-danger btn-sm mt-1';
+        remove.textContent = 'Delete';
+        remove.addEventListener('click', () => this.remove(image));
+        figure.append(img, caption, move, remove);
+        return figure;
+    },
+
+    async upload() {
+        const form = new FormData();
+        [...this.elements.uploadFiles.files].forEach(file => form.append('image', file));
+        try {
+            await this.request('images', { dir: this.elements.uploadDir.value.trim() }, { method: 'POST', body: form });
+            this.elements.uploadForm.reset();
+            await this.refreshDecks();
+            await this.loadImages();
+        } catch (error) {
+            this.showError(error);
+        }
+    },
+
+    async move(image, to) {
+        try {
+            await this.request('images/move', {}, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ dir: image.dir, name: image.name, to })
+            });
+            await this.refreshDecks();
+            await this.loadImages();
+        } catch (error) {
+            this.showError(error);
+        }
+    },
+
+    async remove(image) {
+        if (!confirm(`Delete ${image.dir}/${image.name}?`)) return;
+        try {
+            await this.request('images', { dir: image.dir, name: image.name }, { method: 'DELETE' });
+            await this.refreshDecks();
+            await this.loadImages();
+        } catch (error) {
+            this.showError(error);
+        }
+    },
+
+    async preview() {
+        try {
+            /** @type {{deck: string, seed: number, questions: {caption?: string, options: {dir: string, name: string}[], correct: number}[]}} */
+            const preview = await this.request('preview', {
+                seed: this.elements.previewSeed.value.trim(),
+                difficulty: this.elements.previewDifficulty.value
+            });
+            this.elements.previewSeedUsed.textContent = `Seed ${preview.seed}, as played from ?seed=${preview.seed} by a player who has not seen any images yet`;
+            this.elements.previewQuestions.replaceChildren(...preview.questions.map(question => {
+                const item = document.createElement('li');
+                item.className = 'mb-3';
+                if (question.caption) item.append(question.caption);
+                const row = document.createElement('div');
+                row.className = 'd-flex flex-wrap gap-2';
+                row.append(...question.options.map((image, i) => {
+                    const img = document.createElement('img');
+                    img.className = 'thumb' + (i === question.correct ? ' thumb-correct' : '');
+                    img.src = this.imageUrl(image);
+                    img.title = `${image.dir}/${image.name}`;
+                    return img;
+                }));
+                item.append(row);
+                return item;
+            }));
+            this.showError();
+        } catch (error) {
+            this.showError(error);
+        }
+    },
+
+    async loadStats() {
+        this.elements.statsCsv.href = 'stats?' + new URLSearchParams({ deck: this.deck, format: 'csv' });
+        try {
+            /** @type {{images: {image: string, shown: number, correct: number, incorrect: number, fooled: number, meanResponseMs: number}[]}} */
+            const report = await this.request('stats');
+            this.elements.statsRows.replaceChildren(...report.images.map(image => {
+                const row = document.createElement('tr');
+                const seconds = image.meanResponseMs ? `${(image.meanResponseMs / 1000).toFixed(1)}s` : '';
+                [image.image, image.shown, image.correct, image.incorrect, image.fooled, seconds].forEach(value => {
+                    const cell = document.createElement('td');
+                    cell.textContent = value;
+                    row.appendChild(cell);
+                });
+                return row;
+            }));
+            this.showError();
+        } catch (error) {
+            this.showError(error);
+        }
+    },
+
+    async rotatePassword() {
+        const signOut = this.elements.passwordSignOut.checked;
+        if (!confirm(`Change the game password of ${this.deck}?` + (signOut ? ' Everyone will have to log in again.' : ''))) return;
+        try {
+            await this.request('password', {}, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ password: this.elements.passwordInput.value, signOut })
+            });
+            this.elements.passwordForm.reset();
+            await this.refreshDecks();
+            this.showError();
+        } catch (error) {
+            this.showError(error);
+        }
+    }
+};
+
+// Returns a random password that is easy to read out
+const generatePassword = () => {
+    const alphabet = 'abcdefghjkmnpqrstuvwxyz23456789';
+    const bytes = crypto.getRandomValues(new Uint8Array(12));
+    return [...bytes].map(b => alphabet[b % alphabet.length]).join('').replace(/(.{4})(?!$)/g, '$1-');
+};
+
+document.addEventListener('DOMContentLoaded', () => Console.init());
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"whos-your-mate/config"
+)
+
+// TestConsoleHandler tests that the console is served from the binary
+func TestConsoleHandler(t *testing.T) {
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/admin/", http.StatusOK, "Admin Console"},
+		{"/admin/console.js", http.StatusOK, "rotatePassword"},
+		{"/admin/nope.html", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			consoleHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("Expected %q in the response, got %s", tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestAdminDecksHandler tests listing the decks with their image counts
+func TestAdminDecksHandler(t *testing.T) {
+	withTestCatalog(t, 2, 2, 1)
+	withPasswords(t, newPasswordStore())
+	if err := passwords.rotate(config.DefaultDeck, "rotated", false); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	adminDecksHandler(w, httptest.NewRequest(http.MethodGet, "/admin/decks", nil))
+	var decks []AdminDeck
+	if err := json.NewDecoder(w.Body).Decode(&decks); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(decks) == 0 || decks[0].Name != config.DefaultDeck {
+		t.Fatalf("Expected the default deck first, got %+v", decks)
+	}
+	if d := decks[0]; d.ChoiceA != 2 || d.ChoiceB != 2 || d.Ending != 1 || d.PasswordRotatedAt == nil {
+		t.Errorf("Unexpected deck: %+v", d)
+	}
+}
+
+// TestAdminPreviewHandler tests that the preview matches the game of a seed
+func TestAdminPreviewHandler(t *testing.T) {
+	deck, _ := config.Env().Deck(config.DefaultDeck)
+	wrong := deck.QuestionCount * (deck.OptionCount - 1)
+	c := withTestCatalog(t, deck.QuestionCount, wrong, 1)
+	want, answers, err := buildQuestions(newRand(42), c.Snapshot(), deck.QuestionCount, deck.OptionCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	adminPreviewHandler(w, httptest.NewRequest(http.MethodGet, "/admin/preview?seed=42", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var preview AdminPreview
+	if err := json.NewDecoder(w.Body).Decode(&preview); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if preview.Seed != 42 || len(preview.Questions) != len(want) {
+		t.Fatalf("Unexpected preview: %+v", preview)
+	}
+	for i, q := range preview.Questions {
+		if q.Correct != answers[i].Correct {
+			t.Errorf("Question %d: expected correct option %d, got %d", i, answers[i].Correct, q.Correct)
+		}
+		if correct := q.Options[q.Correct]; correct.Dir != "choice_a" || !strings.HasSuffix(want[i].Options[q.Correct], correct.Name) {
+			t.Errorf("Question %d: unexpected correct image %+v", i, correct)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	adminPreviewHandler(w, httptest.NewRequest(http.MethodGet, "/admin/preview?seed=-1", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid seed, got %d", w.Code)
+	}
+
+	withTestCatalog(t, deck.QuestionCount-1, wrong, 1)
+	w = httptest.NewRecorder()
+	adminPreviewHandler(w, httptest.NewRequest(http.MethodGet, "/admin/preview", nil))
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "Not enough images") {
+		t.Errorf("Expected status 409 for too few images, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestAdminImageFileHandler tests serving images to the console
+func TestAdminImageFileHandler(t *testing.T) {
+	withTestCatalog(t, 2, 2, 1)
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"dir=choice_a&name=imga.jpg", http.StatusOK},
+		{"dir=choice_a&name=nope.jpg", http.StatusNotFound},
+		{"dir=choice_a&name=../choice_b/imga.jpg", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			adminImageFileHandler(w, httptest.NewRequest(http.MethodGet, "/admin/image?"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+// TestAdminPasswordHandler tests rotating the game password
+func TestAdminPasswordHandler(t *testing.T) {
+	withPasswords(t, newPasswordStore())
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"wrong method", http.MethodGet, "", "", http.StatusMethodNotAllowed},
+		{"form body", http.MethodPost, "text/plain", `{"password":"evil"}`, http.StatusUnsupportedMediaType},
+		{"malformed body", http.MethodPost, "application/json", "{", http.StatusBadRequest},
+		{"empty password", http.MethodPost, "application/json", `{"password":"  "}`, http.StatusBadRequest},
+		{"rotate", http.MethodPost, "application/json; charset=utf-8", `{"password":"new-secret","signOut":true}`, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/admin/password", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			adminPasswordHandler(w, req)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	// Logins use the new password from now on
+	deck, _ := config.Env().Deck(config.DefaultDeck)
+	if got := passwords.password(deck); got != "new-secret" {
+		t.Errorf("Expected the rotated password, got %q", got)
+	}
+	w := httptest.NewRecorder()
+	loginHandler(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"password":"new-secret"}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected logging in with the new password to work, got %d", w.Code)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// corsMethods may be used by cross-site requests
+	corsMethods = []string{http.MethodGet, http.MethodPost}
+	// corsHeaders may be sent by cross-site requests besides the
+	// CORS-safelisted ones
+	corsHeaders = []string{"Content-Type", "Authorization"}
+)
+
+// corsMaxAge is how long browsers may cache a successful preflight
+const corsMaxAge = 10 * time.Minute
+
+// corsPolicy decides which other sites may call the API from a browser.
+// It only adds CORS headers; authorization is left to requireSession.
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	subdomains  []string // "https://.example.com" for "https://*.example.com"
+	credentials bool
+}
+
+// cors is the policy of the server; main replaces it with the configured one
+var cors = newCORSPolicy(nil, false)
+
+// newCORSPolicy builds a policy from origins like "https://example.com",
+// "https://*.example.com" or "*". Origins matched only through "*" are
+// never allowed to send credentials.
+func newCORSPolicy(origins []string, credentials bool) *corsPolicy {
+	p := &corsPolicy{origins: make(map[string]bool), credentials: credentials}
+	for _, origin := range origins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			p.subdomains = append(p.subdomains, strings.Replace(origin, "://*.", "://.", 1))
+		default:
+			p.origins[origin] = true
+		}
+	}
+	return p
+}
+
+// listed reports whether origin is allowed by name rather than through "*"
+func (p *corsPolicy) listed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, pattern := range p.subdomains {
+		scheme, suffix, _ := strings.Cut(pattern, "://")
+		rest, ok := strings.CutPrefix(origin, scheme+"://")
+		if ok && strings.HasSuffix(rest, suffix) && len(rest) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin sets the headers granting origin access to the response and
+// reports whether it is allowed at all
+func (p *corsPolicy) allowOrigin(h http.Header, origin string) bool {
+	switch {
+	case p.listed(origin):
+		h.Set("Access-Control-Allow-Origin", origin)
+		if p.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	case p.anyOrigin:
+		h.Set("Access-Control-Allow-Origin", "*")
+	default:
+		return false
+	}
+	return true
+}
+
+// handler applies the policy to every request. Preflight requests are
+// answered here; other requests are passed on whether or not their origin
+// is allowed, since only the browser enforces CORS.
+func (p *corsPolicy) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			if origin != "" {
+				p.allowOrigin(h, origin)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if origin == "" || !p.allowOrigin(h, origin) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		if method := r.Header.Get("Access-Control-Request-Method"); !slices.Contains(corsMethods, method) {
+			clearCORS(h)
+			http.Error(w, "Method not allowed", http.StatusForbidden)
+			return
+		}
+		for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+			if header != "" && !slices.Contains(corsHeaders, header) {
+				clearCORS(h)
+				http.Error(w, "Header not allowed: "+header, http.StatusForbidden)
+				return
+			}
+		}
+
+		h.Set("Access-Control-Allow-Methods", strings.Join(corsMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(corsHeaders, ", "))
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// clearCORS withdraws the access granted by allowOrigin
+func clearCORS(h http.Header) {
+	h.Del("Access-Control-Allow-Origin")
+	h.Del("Access-Control-Allow-Credentials")
+}
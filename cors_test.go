@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCORSPolicy tests the headers of simple cross-site requests
+func TestCORSPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		origin      string
+		allowOrigin string
+		allowCreds  string
+	}{
+		{"no origins configured", nil, false, "https://example.com", "", ""},
+		{"same-site request", []string{"https://example.com"}, false, "", "", ""},
+		{"listed origin", []string{"https://example.com"}, false, "https://example.com", "https://example.com", ""},
+		{"listed origin with credentials", []string{"https://example.com"}, true, "https://example.com", "https://example.com", "true"},
+		{"unlisted origin", []string{"https://example.com"}, true, "https://evil.example", "", ""},
+		{"other scheme", []string{"https://example.com"}, false, "http://example.com", "", ""},
+		{"subdomain wildcard", []string{"https://*.example.com"}, false, "https://blog.example.com", "https://blog.example.com", ""},
+		{"subdomain wildcard excludes apex", []string{"https://*.example.com"}, false, "https://example.com", "", ""},
+		{"subdomain wildcard excludes lookalike", []string{"https://*.example.com"}, false, "https://badexample.com", "", ""},
+		{"any origin", []string{"*"}, false, "https://example.com", "*", ""},
+		{"any origin never gets credentials", []string{"*"}, true, "https://example.com", "*", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/game-data", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			called := false
+			w := httptest.NewRecorder()
+			newCORSPolicy(tt.origins, tt.credentials).handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})).ServeHTTP(w, req)
+
+			if !called {
+				t.Error("Expected the request to reach the handler")
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Expected Access-Control-Allow-Origin %q, got %q", tt.allowOrigin, got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.allowCreds {
+				t.Errorf("Expected Access-Control-Allow-Credentials %q, got %q", tt.allowCreds, got)
+			}
+			if got := w.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("Expected Vary: Origin, got %q", got)
+			}
+		})
+	}
+}
+
+// TestCORSPreflight tests that preflight requests are validated and answered
+func TestCORSPreflight(t *testing.T) {
+	policy := newCORSPolicy([]string{"https://example.com"}, true)
+
+	tests := []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		status  int
+	}{
+		{"allowed", "https://example.com", "POST", "content-type, authorization", http.StatusNoContent},
+		{"allowed without headers", "https://example.com", "GET", "", http.StatusNoContent},
+		{"unlisted origin", "https://evil.example", "POST", "", http.StatusForbidden},
+		{"method not allowed", "https://example.com", "DELETE", "", http.StatusForbidden},
+		{"header not allowed", "https://example.com", "POST", "Content-Type, X-Admin", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, "/answer", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+
+			w := httptest.NewRecorder()
+			policy.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("Preflight requests should not reach the handler")
+			})).ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			allowed := tt.status == http.StatusNoContent
+			if got := w.Header().Get("Access-Control-Allow-Origin"); (got != "") != allowed {
+				t.Errorf("Unexpected Access-Control-Allow-Origin %q", got)
+			}
+			if allowed {
+				for header, want := range map[string]string{
+					"Access-Control-Allow-Methods":     "GET, POST",
+					"Access-Control-Allow-Headers":     "Content-Type, Authorization",
+					"Access-Control-Allow-Credentials": "true",
+					"Access-Control-Max-Age":           "600",
+				} {
+					if got := w.Header().Get(header); got != want {
+						t.Errorf("Expected header %s to be %s, got %s", header, want, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestCORSPlainOptions tests that OPTIONS requests without a requested
+// method are not treated as preflights
+func TestCORSPlainOptions(t *testing.T) {
+	w := httptest.NewRecorder()
+	called := false
+	newCORSPolicy([]string{"*"}, false).handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/", nil))
+	if !called {
+		t.Error("Expected the request to reach the handler")
+	}
+}
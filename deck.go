@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"whos-your-mate/config"
+)
+
+type contextKey int
+
+const deckContextKey contextKey = iota
+
+// catalogs holds the image catalog of every deck by name; it is set up in main
+var catalogs map[string]*imageCatalog
+
+// DeckInfo is the public description of a deck used by the frontend
+type DeckInfo struct {
+	Name  string           `json:"name"`
+	Texts config.DeckTexts `json:"texts"`
+}
+
+// withDeck resolves the deck a request addresses, either through a
+// /d/<deck>/ path prefix, which is stripped, or a ?deck= query parameter.
+// Requests without either use the default deck.
+func withDeck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("deck")
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/d/"); ok {
+			var path string
+			name, path, ok = strings.Cut(rest, "/")
+			if !ok {
+				// Relative asset links need the trailing slash
+				http.Redirect(w, r, "/d/"+name+"/", http.StatusMovedPermanently)
+				return
+			}
+			r.URL.Path = "/" + path
+			r.URL.RawPath = ""
+		}
+		if name == "" {
+			name = config.DefaultDeck
+		}
+
+		deck, ok := config.Env().Deck(name)
+		if !ok {
+			http.Error(w, "Unknown deck", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), deckContextKey, deck)))
+	})
+}
+
+// deckOf returns the deck resolved by withDeck, or the default deck
+func deckOf(r *http.Request) *config.Deck {
+	if deck, ok := r.Context().Value(deckContextKey).(*config.Deck); ok {
+		return deck
+	}
+	deck, _ := config.Env().Deck(config.DefaultDeck)
+	return deck
+}
+
+// deckHandler serves the public info of the requested deck
+func deckHandler(w http.ResponseWriter, r *http.Request) {
+	deck := deckOf(r)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(DeckInfo{Name: deck.Name, Texts: deck.Texts}); err != nil {
+		respondWithError(w, "Could not encode deck", err)
+	}
+}
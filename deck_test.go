@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"whos-your-mate/config"
+)
+
+// TestWithDeck tests resolving the deck from the path or query
+func TestWithDeck(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		status   int
+		wantDeck string
+		wantPath string
+	}{
+		{"no deck", "/game-data", http.StatusOK, config.DefaultDeck, "/game-data"},
+		{"query deck", "/game-data?deck=default", http.StatusOK, config.DefaultDeck, "/game-data"},
+		{"path deck", "/d/default/game-data", http.StatusOK, config.DefaultDeck, "/game-data"},
+		{"path deck root", "/d/default/", http.StatusOK, config.DefaultDeck, "/"},
+		{"missing slash", "/d/default", http.StatusMovedPermanently, "", ""},
+		{"unknown query deck", "/game-data?deck=nope", http.StatusNotFound, "", ""},
+		{"unknown path deck", "/d/nope/game-data", http.StatusNotFound, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotDeck, gotPath string
+			handler := withDeck(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotDeck = deckOf(r).Name
+				gotPath = r.URL.Path
+			}))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if gotDeck != tt.wantDeck || gotPath != tt.wantPath {
+				t.Errorf("Expected deck %q at %q, got %q at %q", tt.wantDeck, tt.wantPath, gotDeck, gotPath)
+			}
+		})
+	}
+}
+
+// TestDeckHandler tests the public deck info endpoint
+func TestDeckHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	withDeck(http.HandlerFunc(deckHandler)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/d/default/deck", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var info DeckInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if info.Name != config.DefaultDeck {
+		t.Errorf("Expected deck %s, got %s", config.DefaultDeck, info.Name)
+	}
+}
+
+// TestGamesAreScopedToDecks tests that a game cannot be used from another deck
+func TestGamesAreScopedToDecks(t *testing.T) {
+	g, err := games.create("alice", "", 0, false, twoWay(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := games.publish(g, "images/alice/choice_a/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := url[len("/img/"):]
+
+	if _, ok := games.imagePath("bob", token); ok {
+		t.Error("Expected image token to be hidden from another deck")
+	}
+	if _, _, err := games.answer("bob", g.id, 0, 0); err != errGameNotFound {
+		t.Errorf("Expected errGameNotFound from another deck, got %v", err)
+	}
+	if _, _, err := games.answer("alice", g.id, 0, 0); err != nil {
+		t.Errorf("Expected answer from own deck to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strings"
+)
+
+// Score tiers of a finished game. Ending images are grouped by tier in
+// subdirectories of the ending directory named after the tier, or by the
+// tier field of their metadata.
+const (
+	tierPerfect = "perfect" // every question answered correctly
+	tierGood    = "good"    // at least half of the questions
+	tierPoor    = "poor"    // fewer than half
+)
+
+// isTier reports whether name is one of the score tiers
+func isTier(name string) bool {
+	return name == tierPerfect || name == tierGood || name == tierPoor
+}
+
+// scoreTier returns the tier a game result falls into
+func scoreTier(r *GameResult) string {
+	switch {
+	case r.Won:
+		return tierPerfect
+	case r.Correct*2 >= r.Total:
+		return tierGood
+	default:
+		return tierPoor
+	}
+}
+
+// groupEndings sorts the ending images of dir by tier. Images without a tier
+// are grouped under "".
+func groupEndings(dir string, ending []string, meta map[string]ImageMeta) map[string][]string {
+	tiers := make(map[string][]string)
+	for _, img := range ending {
+		tier := meta[img].Tier
+		if tier == "" {
+			if rel, err := filepath.Rel(dir, img); err == nil {
+				if first, _, ok := strings.Cut(filepath.ToSlash(rel), "/"); ok && isTier(first) {
+					tier = first
+				}
+			}
+		}
+		tiers[tier] = append(tiers[tier], img)
+	}
+	return tiers
+}
+
+// pickEnding chooses a random ending image for the tier from r. Perfect games fall
+// back to images without a tier, so that decks without tiers keep showing
+// their ending only to winners. Returns "" if there is no fitting image.
+func pickEnding(r *rand.Rand, endings map[string][]string, tier string) string {
+	candidates := endings[tier]
+	if len(candidates) == 0 && tier == tierPerfect {
+		candidates = endings[""]
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[randomIndex(r, len(candidates))]
+}
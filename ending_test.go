@@ -0,0 +1,92 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestScoreTier tests the tier of game results
+func TestScoreTier(t *testing.T) {
+	tests := []struct {
+		result   GameResult
+		expected string
+	}{
+		{GameResult{Correct: 5, Total: 5, Won: true}, tierPerfect},
+		{GameResult{Correct: 4, Total: 5}, tierGood},
+		{GameResult{Correct: 2, Total: 4}, tierGood},
+		{GameResult{Correct: 2, Total: 5}, tierPoor},
+		{GameResult{Correct: 0, Total: 1}, tierPoor},
+	}
+
+	for _, tt := range tests {
+		if got := scoreTier(&tt.result); got != tt.expected {
+			t.Errorf("scoreTier(%+v): expected %s, got %s", tt.result, tt.expected, got)
+		}
+	}
+}
+
+// TestGroupEndings tests grouping ending images by subdirectory and metadata
+func TestGroupEndings(t *testing.T) {
+	dir := "./images/ending"
+	perfect := filepath.Join("images", "ending", "perfect", "a.jpg")
+	good := filepath.Join("images", "ending", "good", "b.jpg")
+	tagged := filepath.Join("images", "ending", "c.jpg")
+	overridden := filepath.Join("images", "ending", "good", "d.jpg")
+	plain := filepath.Join("images", "ending", "party", "e.jpg")
+	meta := map[string]ImageMeta{
+		tagged:     {Tier: tierPoor},
+		overridden: {Tier: tierPerfect},
+	}
+
+	endings := groupEndings(dir, []string{perfect, good, tagged, overridden, plain}, meta)
+
+	tests := []struct {
+		tier string
+		want []string
+	}{
+		{tierPerfect, []string{perfect, overridden}},
+		{tierGood, []string{good}},
+		{tierPoor, []string{tagged}},
+		{"", []string{plain}},
+	}
+	for _, tt := range tests {
+		got := endings[tt.tier]
+		if len(got) != len(tt.want) {
+			t.Errorf("Tier %q: expected %v, got %v", tt.tier, tt.want, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Tier %q: expected %v, got %v", tt.tier, tt.want, got)
+			}
+		}
+	}
+}
+
+// TestPickEnding tests which images each tier can end with
+func TestPickEnding(t *testing.T) {
+	untiered := map[string][]string{"": {"plain.jpg"}}
+	tiered := map[string][]string{"": {"plain.jpg"}, tierPerfect: {"perfect.jpg"}, tierPoor: {"poor.jpg"}}
+
+	tests := []struct {
+		name     string
+		endings  map[string][]string
+		tier     string
+		expected string
+	}{
+		{"perfect", tiered, tierPerfect, "perfect.jpg"},
+		{"poor", tiered, tierPoor, "poor.jpg"},
+		{"tier without images", tiered, tierGood, ""},
+		{"perfect falls back to untiered", untiered, tierPerfect, "plain.jpg"},
+		{"untiered only for winners", untiered, tierPoor, ""},
+		{"no endings", nil, tierPerfect, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickEnding(newRand(1), tt.endings, tt.tier); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
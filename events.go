@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventBuffer is how many events a subscriber may fall behind before
+	// it is dropped; its client reconnects and catches up
+	eventBuffer = 16
+	// heartbeatInterval keeps idle event streams open through proxies
+	heartbeatInterval = 15 * time.Second
+	// eventClosed ends the streams of a topic
+	eventClosed = "closed"
+)
+
+// busTopic is what an event is about: the solo games of a deck, or a party
+// room of a deck
+type busTopic struct {
+	deck string
+	room string // party room code, empty for solo games
+}
+
+// busEvent is an event of a game, sent to clients as a server-sent event
+type busEvent struct {
+	topic busTopic
+	name  string
+	data  any
+}
+
+// eventBus passes game events from the flows that cause them to the
+// streams following their topic
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan busEvent]busTopic
+}
+
+// bus carries the events of all decks
+var bus = newEventBus()
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: make(map[chan busEvent]busTopic)}
+}
+
+// publish sends an event to everyone following its topic. Subscribers that
+// fall too far behind are dropped.
+func (b *eventBus) publish(event busEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events, topic := range b.subscribers {
+		if topic != event.topic {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// subscribe returns a stream of the events of a topic, starting with the
+// given ones. The stream is closed when it is dropped.
+func (b *eventBus) subscribe(topic busTopic, initial ...busEvent) chan busEvent {
+	events := make(chan busEvent, max(eventBuffer, len(initial)))
+	for _, event := range initial {
+		events <- event
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[events] = topic
+	return events
+}
+
+// unsubscribe ends a stream returned by subscribe
+func (b *eventBus) unsubscribe(events chan busEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[events]; ok {
+		delete(b.subscribers, events)
+		close(events)
+	}
+}
+
+// SoloStarted is published when a player starts a solo game
+type SoloStarted struct {
+	Player        string `json:"player"`
+	Mode          string `json:"mode,omitempty"`
+	QuestionCount int    `json:"questionCount"`
+}
+
+// publishSolo publishes an event of the solo games of a deck
+func publishSolo(deck, name string, data any) {
+	bus.publish(busEvent{topic: busTopic{deck: deck}, name: name, data: data})
+}
+
+// presentHandler streams the events of a deck to a big screen as
+// server-sent events. With ?code= it follows a party room: lobby,
+// question, answers, scores and winner. Without, it follows the solo games
+// of the deck as they start and finish.
+func presentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deck := deckOf(r).Name
+	code := strings.TrimSpace(r.URL.Query().Get("code"))
+	if code == "" {
+		events := bus.subscribe(busTopic{deck: deck})
+		defer bus.unsubscribe(events)
+		streamEvents(w, r, events)
+		return
+	}
+
+	events, err := parties.present(deck, code)
+	if err != nil {
+		partyError(w, err)
+		return
+	}
+	defer bus.unsubscribe(events)
+	streamEvents(w, r, events)
+}
+
+// streamEvents writes events as server-sent events until the client goes
+// away, the stream is dropped or its topic is closed
+func streamEvents(w http.ResponseWriter, r *http.Request, events <-chan busEvent) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, "Streaming is not supported", errors.New("response writer cannot flush"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event.name, event.data); err != nil {
+				log.Println("Could not send event:", err)
+				return
+			}
+			if event.name == eventClosed {
+				flusher.Flush()
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a server-sent event with data encoded as JSON
+func writeEvent(w io.Writer, name string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, b)
+	return err
+}
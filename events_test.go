@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// TestEventBus tests that events reach the subscribers of their topic only
+func TestEventBus(t *testing.T) {
+	b := newEventBus()
+	solo := busTopic{deck: config.DefaultDeck}
+	room := busTopic{deck: config.DefaultDeck, room: "ABCDE"}
+
+	soloEvents := b.subscribe(solo)
+	roomEvents := b.subscribe(room, busEvent{topic: room, name: "lobby"})
+	b.publish(busEvent{topic: solo, name: "started"})
+	b.publish(busEvent{topic: busTopic{deck: "alice"}, name: "started"})
+
+	if event := <-soloEvents; event.name != "started" {
+		t.Errorf("Expected started, got %s", event.name)
+	}
+	if event := <-roomEvents; event.name != "lobby" {
+		t.Errorf("Expected the initial lobby, got %s", event.name)
+	}
+	select {
+	case event := <-soloEvents:
+		t.Errorf("Expected events of other decks to be filtered, got %+v", event)
+	case event := <-roomEvents:
+		t.Errorf("Expected solo events to be filtered from rooms, got %+v", event)
+	default:
+	}
+
+	b.unsubscribe(soloEvents)
+	b.unsubscribe(soloEvents)
+	if _, ok := <-soloEvents; ok {
+		t.Error("Expected the stream to be closed")
+	}
+	b.publish(busEvent{topic: solo, name: "started"})
+}
+
+// TestEventBusSlowSubscriber tests that subscribers falling behind are dropped
+func TestEventBusSlowSubscriber(t *testing.T) {
+	b := newEventBus()
+	topic := busTopic{deck: config.DefaultDeck}
+	events := b.subscribe(topic)
+	for i := 0; i <= eventBuffer; i++ {
+		b.publish(busEvent{topic: topic, name: "started"})
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	if received != eventBuffer {
+		t.Errorf("Expected %d events before the drop, got %d", eventBuffer, received)
+	}
+	if len(b.subscribers) != 0 {
+		t.Errorf("Expected the subscriber to be dropped, got %d", len(b.subscribers))
+	}
+}
+
+// TestPresentHandler tests following solo games and unknown rooms
+func TestPresentHandler(t *testing.T) {
+	t.Run("unknown room", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		presentHandler(w, httptest.NewRequest(http.MethodGet, "/present?code=NOPE", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		presentHandler(w, httptest.NewRequest(http.MethodPost, "/present", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status 405, got %d", w.Code)
+		}
+	})
+
+	t.Run("solo games", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			presentHandler(w, httptest.NewRequest(http.MethodGet, "/present", nil).WithContext(ctx))
+			close(done)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		publishSolo(config.DefaultDeck, "finished", ScoreEntry{Player: "Bob", Deck: config.DefaultDeck, Correct: 3, Total: 5})
+		publishSolo("alice", "finished", ScoreEntry{Player: "Carol", Deck: "alice"})
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		<-done
+
+		body := w.Body.String()
+		if !strings.Contains(body, "event: finished\ndata: ") || !strings.Contains(body, `"player":"Bob"`) {
+			t.Errorf("Expected the finished game, got %s", body)
+		}
+		if strings.Contains(body, "Carol") {
+			t.Errorf("Expected games of other decks to be left out, got %s", body)
+		}
+	})
+}
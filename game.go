@@ -0,0 +1,551 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// gameTTL is how long an unfinished game is kept before it is discarded
+	gameTTL = time.Hour
+	// pointsPerAnswer is what a correct answer scores; in speed games half
+	// of it is scaled by the time left
+	pointsPerAnswer = 1000
+	// answerGrace is added to the deadline of a timed question to make up
+	// for the time an answer takes to reach the server
+	answerGrace = 500 * time.Millisecond
+	// noOption is the option of a timed question whose time ran out
+	noOption = -1
+)
+
+// modeSpeed games ask their questions one at a time, each with a deadline
+const modeSpeed = "speed"
+
+var (
+	errGameNotFound    = errors.New("game not found")
+	errGameFinished    = errors.New("game already finished")
+	errQuestionOrder   = errors.New("question answered out of order")
+	errInvalidQuestion = errors.New("invalid question index")
+	errInvalidOption   = errors.New("invalid option")
+	errNotTimed        = errors.New("game is not timed")
+	errNotAsked        = errors.New("question has not been asked yet")
+)
+
+// AnswerRequest is the body of a POST to /answer
+type AnswerRequest struct {
+	GameID   string `json:"gameId"`
+	Question int    `json:"question"` // 0-based question index
+	Option   *int   `json:"option"`   // index of the selected image in the question's options
+	// GiveUp answers a question of a speed game without an option once its
+	// time has run out
+	GiveUp bool `json:"giveUp,omitempty"`
+}
+
+// NextRequest is the body of a POST to /next
+type NextRequest struct {
+	GameID string `json:"gameId"`
+}
+
+// NextQuestion is a question of a speed game, handed out when it is asked
+type NextQuestion struct {
+	Index int `json:"index"` // 0-based question index
+	Question
+	TimeLimitMs int64 `json:"timeLimitMs"`
+	RemainingMs int64 `json:"remainingMs"` // until the deadline, as measured by the server
+}
+
+// AnswerResponse tells the client whether its choice was right and reveals
+// the correct option and what is known about the images of the question
+type AnswerResponse struct {
+	Correct  bool        `json:"correct"`
+	Late     bool        `json:"late,omitempty"` // the deadline of a timed question had passed
+	Points   int         `json:"points"`         // scored by this answer
+	Answer   int         `json:"answer"`         // index of the correct option
+	Reveal   []ImageMeta `json:"reveal,omitempty"`
+	Finished bool        `json:"finished"`
+	Result   *GameResult `json:"result,omitempty"`
+}
+
+// GameResult is the final outcome of a game
+type GameResult struct {
+	Correct     int    `json:"correct"`
+	Total       int    `json:"total"`
+	Won         bool   `json:"won"`
+	Points      int    `json:"points"`
+	TimeTakenMs int64  `json:"timeTakenMs"` // from handing out the game to the last answer
+	Tier        string `json:"tier"`        // perfect, good or poor
+	EndingPhoto string `json:"endingPhoto,omitempty"`
+}
+
+// answerKey is the part of a question that stays on the server
+type answerKey struct {
+	Correct int         // index into the question's options
+	Options int         // number of options shown
+	Reveal  []ImageMeta // metadata of each option, nil if there is none
+	Images  []string    // file of each option, for the statistics
+}
+
+// answerOutcome is what an answer adds to the image statistics and, once
+// it finishes the game, to the leaderboard
+type answerOutcome struct {
+	stat  answerStat
+	score *ScoreEntry
+	// replay keeps the score of a game replayed from a requested seed off
+	// the leaderboard, since its answers may be known
+	replay bool
+}
+
+// gameSession keeps the answers of a single game on the server. Timed
+// games also keep their questions, which are asked one at a time: each
+// question is unknown to the client until it is asked, and must be answered
+// before its deadline.
+type gameSession struct {
+	id         string
+	deck       string
+	player     string
+	seed       int64
+	replay     bool // the seed was requested, so the answers may be known
+	answers    []answerKey
+	endings    map[string][]string // ending images by score tier
+	timeLimit  time.Duration       // per question, 0 if the game is not timed
+	questions  []Question          // unpublished questions of a timed game
+	asked      *NextQuestion       // the current question once it is asked
+	answered   int
+	correct    int
+	points     int
+	finished   bool
+	createdAt  time.Time
+	askedAt    time.Time // when the current question became available
+	deadline   time.Time // of the current question of a timed game
+	finishedAt time.Time
+	tokens     []string
+}
+
+// mode returns the mode of the game for the leaderboard
+func (g *gameSession) mode() string {
+	if g.timeLimit > 0 {
+		return modeSpeed
+	}
+	return ""
+}
+
+// result returns the outcome of the finished game
+func (g *gameSession) result() *GameResult {
+	r := &GameResult{
+		Correct:     g.correct,
+		Total:       len(g.answers),
+		Won:         g.correct == len(g.answers),
+		Points:      g.points,
+		TimeTakenMs: g.finishedAt.Sub(g.createdAt).Milliseconds(),
+	}
+	r.Tier = scoreTier(r)
+	return r
+}
+
+// score returns the leaderboard entry of the finished game
+func (g *gameSession) score() ScoreEntry {
+	r := g.result()
+	return ScoreEntry{
+		Player:      g.player,
+		Deck:        g.deck,
+		Mode:        g.mode(),
+		Correct:     r.Correct,
+		Total:       r.Total,
+		Won:         r.Won,
+		Points:      r.Points,
+		TimeTakenMs: r.TimeTakenMs,
+		FinishedAt:  g.finishedAt,
+	}
+}
+
+// imageRef is the file behind an image token and the deck it belongs to
+type imageRef struct {
+	path string
+	deck string
+}
+
+// gameStore is an in-memory registry of running games and of the opaque
+// image tokens handed out to them
+type gameStore struct {
+	mu     sync.Mutex
+	games  map[string]*gameSession
+	images map[string]imageRef
+	ttl    time.Duration
+}
+
+var games = newGameStore(gameTTL)
+
+func newGameStore(ttl time.Duration) *gameStore {
+	return &gameStore{
+		games:  make(map[string]*gameSession),
+		images: make(map[string]imageRef),
+		ttl:    ttl,
+	}
+}
+
+// create registers a new game of the given deck and player holding the
+// given answer keys and the ending images to choose from once it is over.
+// The seed the questions were built from also picks the ending; replay
+// tells that the client requested it.
+func (s *gameStore) create(deck, player string, seed int64, replay bool, answers []answerKey, endings map[string][]string) (*gameSession, error) {
+	return s.add(&gameSession{deck: deck, player: player, seed: seed, replay: replay, answers: answers, endings: endings})
+}
+
+// createTimed registers a new speed game like create. Its questions stay on
+// the server until they are asked, and each must be answered within limit.
+func (s *gameStore) createTimed(deck, player string, seed int64, replay bool, questions []Question, answers []answerKey, endings map[string][]string, limit time.Duration) (*gameSession, error) {
+	return s.add(&gameSession{deck: deck, player: player, seed: seed, replay: replay, answers: answers, endings: endings, timeLimit: limit, questions: questions})
+}
+
+// add registers g under a new ID
+func (s *gameStore) add(g *gameSession) (*gameSession, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	g.id = id
+	g.createdAt = time.Now()
+	g.askedAt = g.createdAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	s.games[id] = g
+	return g, nil
+}
+
+// lookupLocked returns the running game of the given deck and ID; s.mu
+// must be held
+func (s *gameStore) lookupLocked(deck, id string) (*gameSession, error) {
+	g, ok := s.games[id]
+	if !ok || g.deck != deck || time.Since(g.createdAt) > s.ttl {
+		return nil, errGameNotFound
+	}
+	if g.finished {
+		return nil, errGameFinished
+	}
+	return g, nil
+}
+
+// publish registers an image path for the given game and returns the
+// opaque URL under which it is served
+func (s *gameStore) publish(g *gameSession, path string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.publishLocked(g, path)
+}
+
+// publishLocked is publish for callers that hold s.mu
+func (s *gameStore) publishLocked(g *gameSession, path string) (string, error) {
+	token, err := newID()
+	if err != nil {
+		return "", err
+	}
+	s.images[token] = imageRef{path: path, deck: g.deck}
+	g.tokens = append(g.tokens, token)
+	return "/img/" + token, nil
+}
+
+// publishQuestions replaces the image paths of questions with the URLs
+// under which they are served for the given game
+func (s *gameStore) publishQuestions(g *gameSession, questions []Question) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, q := range questions {
+		for i := range q.Options {
+			url, err := s.publishLocked(g, q.Options[i])
+			if err != nil {
+				return err
+			}
+			q.Options[i] = url
+		}
+	}
+	return nil
+}
+
+// imagePath resolves an image token of the given deck to the file it
+// stands for
+func (s *gameStore) imagePath(deck, token string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ref, ok := s.images[token]
+	if !ok || ref.deck != deck {
+		return "", false
+	}
+	return ref.path, true
+}
+
+// next asks the current question of a speed game of the given deck,
+// publishing its images and starting its clock. Asking again before the
+// question is answered returns the same question without moving its
+// deadline.
+func (s *gameStore) next(deck, id string) (*NextQuestion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, err := s.lookupLocked(deck, id)
+	if err != nil {
+		return nil, err
+	}
+	if g.timeLimit == 0 {
+		return nil, errNotTimed
+	}
+	if g.asked == nil {
+		q := g.questions[g.answered]
+		q.Options = slices.Clone(q.Options)
+		for i := range q.Options {
+			if q.Options[i], err = s.publishLocked(g, q.Options[i]); err != nil {
+				return nil, err
+			}
+		}
+		g.asked = &NextQuestion{Index: g.answered, Question: q, TimeLimitMs: g.timeLimit.Milliseconds()}
+		g.askedAt = time.Now()
+		g.deadline = g.askedAt.Add(g.timeLimit)
+	}
+
+	asked := *g.asked
+	asked.RemainingMs = max(time.Until(g.deadline).Milliseconds(), 0)
+	return &asked, nil
+}
+
+// answer checks the option chosen for the given question of a game of the given
+// deck and advances the game. Questions must be answered in order and a
+// wrong answer ends the game. Questions of a speed game must have been
+// asked, and an answer after their deadline, or noOption once the time ran
+// out, counts as wrong. The answer that finishes a game carries the ending
+// image picked for its score tier, and its leaderboard entry is part of the
+// outcome.
+func (s *gameStore) answer(deck, id string, question, option int) (*AnswerResponse, answerOutcome, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, err := s.lookupLocked(deck, id)
+	if err != nil {
+		return nil, answerOutcome{}, err
+	}
+	if question < 0 || question >= len(g.answers) {
+		return nil, answerOutcome{}, errInvalidQuestion
+	}
+	if question != g.answered {
+		return nil, answerOutcome{}, errQuestionOrder
+	}
+	timed := g.timeLimit > 0
+	if timed && g.asked == nil {
+		return nil, answerOutcome{}, errNotAsked
+	}
+	key := g.answers[question]
+	if (option < 0 || option >= key.Options) && !(timed && option == noOption) {
+		return nil, answerOutcome{}, errInvalidOption
+	}
+
+	now := time.Now()
+	responseTime := now.Sub(g.askedAt)
+	late := timed && now.After(g.deadline.Add(answerGrace))
+	g.askedAt = now
+	g.asked = nil
+	g.answered++
+	correct := key.Correct == option && !late
+	points := 0
+	if correct {
+		g.correct++
+		points = g.pointsFor(responseTime)
+		g.points += points
+	}
+	g.finished = !correct || g.answered == len(g.answers)
+
+	resp := &AnswerResponse{Correct: correct, Late: late, Points: points, Answer: key.Correct, Reveal: key.Reveal, Finished: g.finished}
+	var outcome answerOutcome
+	// Running out of time says nothing about how fooling the images are
+	if !late && option != noOption {
+		outcome.stat = answerStat{
+			Deck:       deck,
+			Images:     key.Images,
+			Correct:    key.Correct,
+			Picked:     option,
+			AnsweredAt: now,
+			ResponseMs: max(responseTime.Milliseconds(), 1), // 0 would mean untimed
+		}
+	}
+	if !g.finished {
+		return resp, outcome, nil
+	}
+	g.finishedAt = now
+	resp.Result = g.result()
+	if photo := pickEnding(newRand(g.seed), g.endings, resp.Result.Tier); photo != "" {
+		url, err := s.publishLocked(g, photo)
+		if err != nil {
+			log.Printf("Could not publish ending of game %s: %v\n", g.id, err)
+		}
+		resp.Result.EndingPhoto = url
+	}
+	score := g.score()
+	outcome.score = &score
+	outcome.replay = g.replay
+	return resp, outcome, nil
+}
+
+// pointsFor returns the points of a correct answer given after
+// responseTime
+func (g *gameSession) pointsFor(responseTime time.Duration) int {
+	return timedPoints(g.timeLimit, responseTime)
+}
+
+// timedPoints returns the points of a correct answer given after
+// responseTime to a question with the given time limit. Untimed answers
+// score pointsPerAnswer; timed answers score half of it plus the other
+// half scaled by the share of time left.
+func timedPoints(limit, responseTime time.Duration) int {
+	if limit == 0 {
+		return pointsPerAnswer
+	}
+	left := min(max(limit-responseTime, 0), limit)
+	return pointsPerAnswer/2 + int(int64(pointsPerAnswer/2)*int64(left)/int64(limit))
+}
+
+// pruneLocked drops games older than the store's TTL; s.mu must be held
+func (s *gameStore) pruneLocked() {
+	for id, g := range s.games {
+		if time.Since(g.createdAt) > s.ttl {
+			for _, token := range g.tokens {
+				delete(s.images, token)
+			}
+			delete(s.games, id)
+		}
+	}
+}
+
+// answerHandler verifies a single answer against the server-side game
+func answerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AnswerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid answer payload", http.StatusBadRequest)
+		return
+	}
+	option := noOption
+	switch {
+	case req.Option != nil && req.GiveUp:
+		http.Error(w, "Either give up or pick an option", http.StatusBadRequest)
+		return
+	case req.Option != nil:
+		option = *req.Option
+	case !req.GiveUp:
+		http.Error(w, "Missing option", http.StatusBadRequest)
+		return
+	}
+
+	deck := deckOf(r).Name
+	resp, outcome, err := games.answer(deck, req.GameID, req.Question, option)
+	switch {
+	case errors.Is(err, errGameNotFound):
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errGameFinished), errors.Is(err, errQuestionOrder), errors.Is(err, errNotAsked):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(outcome.stat.Images) > 0 {
+		if err := stats.record(outcome.stat); err != nil {
+			log.Printf("Could not record answer of game %s: %v\n", req.GameID, err)
+		}
+	}
+	if outcome.score != nil {
+		if !outcome.replay {
+			if err := scores.record(*outcome.score); err != nil {
+				log.Printf("Could not record score of game %s: %v\n", req.GameID, err)
+			}
+		}
+		publishSolo(deck, "finished", *outcome.score)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		respondWithError(w, "Could not encode answer", err)
+	}
+}
+
+// nextHandler asks the current question of a speed game
+func nextHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req NextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid question request", http.StatusBadRequest)
+		return
+	}
+
+	q, err := games.next(deckOf(r).Name, req.GameID)
+	switch {
+	case errors.Is(err, errGameNotFound):
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errGameFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errNotTimed):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		respondWithError(w, "Could not ask question", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(q); err != nil {
+		respondWithError(w, "Could not encode question", err)
+	}
+}
+
+// imageHandler serves the file behind an opaque /img/<token> URL, resized
+// to the variant for ?w= if given
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/img/")
+	path, ok := games.imagePath(deckOf(r).Name, token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeFile(w, r, variants.path(path, parseWidth(r.URL.Query().Get("w"))))
+}
+
+// parseMode checks the game mode requested by a client; the empty mode is
+// the classic game
+func parseMode(s string) (string, error) {
+	switch s {
+	case "", modeSpeed:
+		return s, nil
+	}
+	return "", fmt.Errorf("mode must be %s, got %q", modeSpeed, s)
+}
+
+// newID returns a random 128-bit hex identifier
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
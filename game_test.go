@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// twoWay returns the answer keys of two-option questions with the given
+// correct options
+func twoWay(correct ...int) []answerKey {
+	keys := make([]answerKey, len(correct))
+	for i, c := range correct {
+		keys[i] = answerKey{Correct: c, Options: 2}
+	}
+	return keys
+}
+
+// TestGameStoreAnswer tests answering a game through to the end
+func TestGameStoreAnswer(t *testing.T) {
+	store := newGameStore(time.Hour)
+	g, err := store.create(config.DefaultDeck, "Bob", 0, false, twoWay(0, 1, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, option := range []int{0, 1} {
+		resp, _, err := store.answer(config.DefaultDeck, g.id, i, option)
+		if err != nil {
+			t.Fatalf("answer %d failed: %v", i, err)
+		}
+		if !resp.Correct || resp.Finished {
+			t.Errorf("Expected correct unfinished answer %d, got %+v", i, resp)
+		}
+	}
+
+	resp, outcome, err := store.answer(config.DefaultDeck, g.id, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Finished || resp.Result == nil {
+		t.Fatalf("Expected finished game with result, got %+v", resp)
+	}
+	if !resp.Result.Won || resp.Result.Correct != 3 || resp.Result.Total != 3 || resp.Result.TimeTakenMs < 0 {
+		t.Errorf("Unexpected result: %+v", resp.Result)
+	}
+	if score := outcome.score; score == nil || score.Player != "Bob" || score.Deck != config.DefaultDeck || score.Correct != 3 || score.FinishedAt.IsZero() {
+		t.Errorf("Unexpected score: %+v", score)
+	}
+
+	if _, _, err := store.answer(config.DefaultDeck, g.id, 2, 0); !errors.Is(err, errGameFinished) {
+		t.Errorf("Expected errGameFinished, got %v", err)
+	}
+}
+
+// TestGameStoreWrongAnswerEndsGame tests that a wrong answer finishes the game
+func TestGameStoreWrongAnswerEndsGame(t *testing.T) {
+	store := newGameStore(time.Hour)
+	g, err := store.create(config.DefaultDeck, "", 0, false, twoWay(0, 1, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _, err := store.answer(config.DefaultDeck, g.id, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Answer != 0 {
+		t.Errorf("Expected the correct option to be revealed, got %d", resp.Answer)
+	}
+	if resp.Correct || !resp.Finished {
+		t.Errorf("Expected wrong finished answer, got %+v", resp)
+	}
+	if resp.Result == nil || resp.Result.Won || resp.Result.Correct != 0 {
+		t.Errorf("Unexpected result: %+v", resp.Result)
+	}
+}
+
+// TestGameStoreAnswerReveal tests that image metadata comes with the answer
+func TestGameStoreAnswerReveal(t *testing.T) {
+	store := newGameStore(time.Hour)
+	keys := twoWay(1)
+	keys[0].Reveal = []ImageMeta{{Reveal: "Bob"}, {Caption: "Lisbon"}}
+	g, err := store.create(config.DefaultDeck, "", 0, false, keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _, err := store.answer(config.DefaultDeck, g.id, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Reveal) != 2 || resp.Reveal[0].Reveal != "Bob" || resp.Reveal[1].Caption != "Lisbon" {
+		t.Errorf("Unexpected reveal: %+v", resp.Reveal)
+	}
+}
+
+// TestGameStoreEnding tests that the ending photo is picked for the score
+// tier once the game is over
+func TestGameStoreEnding(t *testing.T) {
+	store := newGameStore(time.Hour)
+	endings := map[string][]string{tierPerfect: {"perfect.jpg"}, tierPoor: {"poor.jpg"}}
+
+	tests := []struct {
+		name    string
+		options []int
+		tier    string
+		photo   string
+	}{
+		{"perfect", []int{0, 1}, tierPerfect, "perfect.jpg"},
+		{"poor", []int{1}, tierPoor, "poor.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := store.create(config.DefaultDeck, "", 0, false, twoWay(0, 1), endings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var resp *AnswerResponse
+			for i, option := range tt.options {
+				if resp, _, err = store.answer(config.DefaultDeck, g.id, i, option); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if resp.Result == nil || resp.Result.Tier != tt.tier {
+				t.Fatalf("Expected tier %s, got %+v", tt.tier, resp.Result)
+			}
+			token := strings.TrimPrefix(resp.Result.EndingPhoto, "/img/")
+			if path, ok := store.imagePath(config.DefaultDeck, token); !ok || path != tt.photo {
+				t.Errorf("Expected ending photo %s, got %q", tt.photo, path)
+			}
+		})
+	}
+}
+
+// TestGameStoreAnswerErrors tests invalid answer submissions
+func TestGameStoreAnswerErrors(t *testing.T) {
+	store := newGameStore(time.Hour)
+	g, err := store.create(config.DefaultDeck, "", 0, false, twoWay(0, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		question int
+		option   int
+		want     error
+	}{
+		{"unknown game", "nope", 0, 0, errGameNotFound},
+		{"question out of range", g.id, 5, 0, errInvalidQuestion},
+		{"question out of order", g.id, 1, 1, errQuestionOrder},
+		{"option out of range", g.id, 0, 2, errInvalidOption},
+		{"negative option", g.id, 0, -1, errInvalidOption},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := store.answer(config.DefaultDeck, tt.id, tt.question, tt.option); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// TestGameStoreExpiry tests that expired games can no longer be answered
+func TestGameStoreExpiry(t *testing.T) {
+	store := newGameStore(time.Hour)
+	g, err := store.create(config.DefaultDeck, "", 0, false, twoWay(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.createdAt = time.Now().Add(-2 * time.Hour)
+
+	if _, _, err := store.answer(config.DefaultDeck, g.id, 0, 0); !errors.Is(err, errGameNotFound) {
+		t.Errorf("Expected errGameNotFound, got %v", err)
+	}
+
+	if _, err := store.create(config.DefaultDeck, "", 0, false, twoWay(0), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.games[g.id]; ok {
+		t.Error("Expected expired game to be pruned")
+	}
+}
+
+// twoWayQuestions returns unpublished two-option questions for timed games
+func twoWayQuestions(n int) []Question {
+	questions := make([]Question, n)
+	for i := range questions {
+		questions[i] = Question{Options: []string{"a.jpg", "b.jpg"}}
+	}
+	return questions
+}
+
+// TestGameStoreTimed tests asking and answering the questions of a speed game
+func TestGameStoreTimed(t *testing.T) {
+	store := newGameStore(time.Hour)
+	g, err := store.createTimed(config.DefaultDeck, "Bob", 0, false, twoWayQuestions(2), twoWay(0, 1), nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := store.answer(config.DefaultDeck, g.id, 0, 0); !errors.Is(err, errNotAsked) {
+		t.Errorf("Expected errNotAsked before the question is asked, got %v", err)
+	}
+
+	q, err := store.next(config.DefaultDeck, g.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Index != 0 || len(q.Options) != 2 || q.TimeLimitMs != time.Minute.Milliseconds() || q.RemainingMs <= 0 {
+		t.Errorf("Unexpected question: %+v", q)
+	}
+	if path, ok := store.imagePath(config.DefaultDeck, strings.TrimPrefix(q.Options[1], "/img/")); !ok || path != "b.jpg" {
+		t.Errorf("Expected a published option, got %s for %q", q.Options[1], path)
+	}
+
+	// Asking again neither moves the deadline nor publishes the images again
+	deadline := g.deadline
+	again, err := store.next(config.DefaultDeck, g.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Index != 0 || again.Options[0] != q.Options[0] || !g.deadline.Equal(deadline) {
+		t.Errorf("Expected the same question and deadline, got %+v", again)
+	}
+
+	resp, _, err := store.answer(config.DefaultDeck, g.id, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Correct || resp.Late || resp.Points <= pointsPerAnswer/2 || resp.Points > pointsPerAnswer {
+		t.Errorf("Expected a quick correct answer, got %+v", resp)
+	}
+
+	if q, err = store.next(config.DefaultDeck, g.id); err != nil || q.Index != 1 {
+		t.Fatalf("Expected the second question, got %+v, %v", q, err)
+	}
+	resp, outcome, err := store.answer(config.DefaultDeck, g.id, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Finished || resp.Result == nil || !resp.Result.Won || resp.Result.Points != g.points {
+		t.Errorf("Unexpected result: %+v", resp.Result)
+	}
+	if score := outcome.score; score == nil || score.Mode != modeSpeed || score.Points != resp.Result.Points {
+		t.Errorf("Unexpected score: %+v", score)
+	}
+
+	if _, err := store.next(config.DefaultDeck, g.id); !errors.Is(err, errGameFinished) {
+		t.Errorf("Expected errGameFinished, got %v", err)
+	}
+}
+
+// TestGameStoreTimedLate tests that answers after the deadline count as wrong
+func TestGameStoreTimedLate(t *testing.T) {
+	store := newGameStore(time.Hour)
+	g, err := store.createTimed(config.DefaultDeck, "", 0, false, twoWayQuestions(2), twoWay(0, 1), nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.next(config.DefaultDeck, g.id); err != nil {
+		t.Fatal(err)
+	}
+	g.deadline = time.Now().Add(-time.Second)
+
+	resp, outcome, err := store.answer(config.DefaultDeck, g.id, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Correct || !resp.Late || !resp.Finished || resp.Points != 0 {
+		t.Errorf("Expected a late wrong answer, got %+v", resp)
+	}
+	if len(outcome.stat.Images) != 0 {
+		t.Errorf("Expected late answers to stay out of the statistics, got %+v", outcome.stat)
+	}
+}
+
+// TestGameStoreTimedGiveUp tests giving up a question whose time ran out
+func TestGameStoreTimedGiveUp(t *testing.T) {
+	store := newGameStore(time.Hour)
+	g, err := store.createTimed(config.DefaultDeck, "", 0, false, twoWayQuestions(1), twoWay(1), nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.next(config.DefaultDeck, g.id); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, _, err := store.answer(config.DefaultDeck, g.id, 0, noOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Correct || !resp.Finished || resp.Answer != 1 {
+		t.Errorf("Expected the game to end revealing the answer, got %+v", resp)
+	}
+
+	// Classic games always need an option
+	classic, err := store.create(config.DefaultDeck, "", 0, false, twoWay(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.answer(config.DefaultDeck, classic.id, 0, noOption); !errors.Is(err, errInvalidOption) {
+		t.Errorf("Expected errInvalidOption, got %v", err)
+	}
+	if _, err := store.next(config.DefaultDeck, classic.id); !errors.Is(err, errNotTimed) {
+		t.Errorf("Expected errNotTimed, got %v", err)
+	}
+}
+
+// TestGameSessionPointsFor tests how response times are scored
+func TestGameSessionPointsFor(t *testing.T) {
+	tests := []struct {
+		name         string
+		limit        time.Duration
+		responseTime time.Duration
+		want         int
+	}{
+		{"classic", 0, time.Minute, pointsPerAnswer},
+		{"instant", 10 * time.Second, 0, pointsPerAnswer},
+		{"half the time", 10 * time.Second, 5 * time.Second, 750},
+		{"at the deadline", 10 * time.Second, 10 * time.Second, 500},
+		{"past the deadline", 10 * time.Second, 11 * time.Second, 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &gameSession{timeLimit: tt.limit}
+			if got := g.pointsFor(tt.responseTime); got != tt.want {
+				t.Errorf("Expected %d points, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestNextHandler tests asking questions through the next endpoint
+func TestNextHandler(t *testing.T) {
+	g, err := games.createTimed(config.DefaultDeck, "", 0, false, twoWayQuestions(1), twoWay(0), nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classic, err := games.create(config.DefaultDeck, "", 0, false, twoWay(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"malformed body", http.MethodPost, "{", http.StatusBadRequest},
+		{"unknown game", http.MethodPost, `{"gameId":"nope"}`, http.StatusNotFound},
+		{"classic game", http.MethodPost, `{"gameId":"` + classic.id + `"}`, http.StatusBadRequest},
+		{"speed game", http.MethodPost, `{"gameId":"` + g.id + `"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			nextHandler(w, httptest.NewRequest(tt.method, "/next", bytes.NewBufferString(tt.body)))
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var q NextQuestion
+			if err := json.NewDecoder(w.Body).Decode(&q); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(q.Options) != 2 || strings.Contains(w.Body.String(), `"correct"`) {
+				t.Errorf("Unexpected question: %s", w.Body.String())
+			}
+		})
+	}
+
+	// Giving up answers the asked question
+	body := `{"gameId":"` + g.id + `","question":0,"giveUp":true}`
+	w := httptest.NewRecorder()
+	answerHandler(w, httptest.NewRequest(http.MethodPost, "/answer", bytes.NewBufferString(body)))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestAnswerHandler tests the answer endpoint
+func TestAnswerHandler(t *testing.T) {
+	saved := stats
+	stats = newStatsLog()
+	defer func() { stats = saved }()
+
+	keys := twoWay(1)
+	keys[0].Images = []string{"a.jpg", "b.jpg"}
+	g, err := games.create(config.DefaultDeck, "", 0, false, keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	option := 1
+	body, _ := json.Marshal(AnswerRequest{GameID: g.id, Question: 0, Option: &option})
+	req := httptest.NewRequest(http.MethodPost, "/answer", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	answerHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp AnswerResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Correct || !resp.Finished || resp.Result == nil || !resp.Result.Won {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if got := stats.images[statKey{config.DefaultDeck, "b.jpg"}]; got.Shown != 1 || got.Correct != 1 {
+		t.Errorf("Expected the answer in the statistics, got %+v", got)
+	}
+
+	// Answering again conflicts with the finished game
+	req = httptest.NewRequest(http.MethodPost, "/answer", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	answerHandler(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", w.Code)
+	}
+}
+
+// TestAnswerHandlerBadRequests tests the answer endpoint with invalid requests
+func TestAnswerHandlerBadRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+		{"malformed body", http.MethodPost, "{", http.StatusBadRequest},
+		{"missing option", http.MethodPost, `{"gameId":"nope","question":0,"choice":1}`, http.StatusBadRequest},
+		{"option and give up", http.MethodPost, `{"gameId":"nope","question":0,"option":0,"giveUp":true}`, http.StatusBadRequest},
+		{"unknown game", http.MethodPost, `{"gameId":"nope","question":0,"option":0}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/answer", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+			answerHandler(w, req)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+// TestImageHandler tests serving images through opaque tokens
+func TestImageHandler(t *testing.T) {
+	tempDir := t.TempDir()
+	imgPath := filepath.Join(tempDir, "secret.jpg")
+	if err := os.WriteFile(imgPath, []byte("image content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := games.create(config.DefaultDeck, "", 0, false, twoWay(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := games.publish(g, imgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, "/img/") || strings.Contains(url, "secret") {
+		t.Errorf("Expected an opaque URL, got %s", url)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	w := httptest.NewRecorder()
+	imageHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w.Body.String() != "image content" {
+		t.Errorf("Unexpected body %q", w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/img/unknown", nil)
+	w = httptest.NewRecorder()
+	imageHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
+
+// TestGameStorePruneDropsTokens tests that expired games release their images
+func TestGameStorePruneDropsTokens(t *testing.T) {
+	store := newGameStore(time.Hour)
+	g, err := store.create(config.DefaultDeck, "", 0, false, twoWay(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := store.publish(g, "images/choice_a/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.createdAt = time.Now().Add(-2 * time.Hour)
+
+	if _, err := store.create(config.DefaultDeck, "", 0, false, twoWay(0), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.imagePath(config.DefaultDeck, strings.TrimPrefix(url, "/img/")); ok {
+		t.Error("Expected token of expired game to be dropped")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// seenHistory remembers which images each player has been shown, so that
+// consecutive games prefer images the player has not seen yet. Players are
+// told apart by their session, which logging in again keeps, and a history
+// is forgotten once it has not been used for as long as a session lasts.
+type seenHistory struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	players map[string]*seenImages // by session ID
+	rounds  map[roundKey]round     // games its seed alone does not reproduce
+}
+
+// seenImages are the images shown to one player since their pool was last
+// exhausted
+type seenImages struct {
+	images   map[string]bool
+	lastSeen time.Time
+}
+
+// roundKey identifies a game by its deck and seed
+type roundKey struct {
+	deck string
+	seed int64
+}
+
+// round is a game that left out images its player had seen or was drawn
+// with weights. Its seed alone does not reproduce it, so it is kept for
+// players replaying the seed.
+type round struct {
+	questions []Question
+	answers   []answerKey
+	createdAt time.Time
+}
+
+// history is shared by all decks; main replaces it with one that lives as
+// long as the configured sessions
+var history = newSeenHistory(defaultSessionTTL)
+
+func newSeenHistory(ttl time.Duration) *seenHistory {
+	return &seenHistory{
+		ttl:     ttl,
+		players: make(map[string]*seenImages),
+		rounds:  make(map[roundKey]round),
+	}
+}
+
+// questions builds the questions of a game of the given seed for player.
+// Images the player has seen are only used once the unseen ones run out,
+// at which point the player's history starts over. A replayed seed returns
+// the remembered game of that seed, or a game built from every image if
+// none is remembered. An empty player has no history.
+func (h *seenHistory) questions(player string, deck *config.Deck, images imageSet, seed int64, replay bool) ([]Question, []answerKey, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pruneLocked()
+
+	key := roundKey{deck: deck.Name, seed: seed}
+	var questions []Question
+	var answers []answerKey
+	if rd, ok := h.rounds[key]; ok && replay {
+		questions, answers = cloneQuestions(rd.questions), slices.Clone(rd.answers)
+	} else {
+		pool, filtered := images, false
+		if player != "" && !replay {
+			pool, filtered = h.unseenLocked(newRand(seed), player, images, deck.QuestionCount, deck.OptionCount)
+		}
+		var err error
+		if questions, answers, err = buildQuestions(newRand(seed), pool, deck.QuestionCount, deck.OptionCount); err != nil {
+			return nil, nil, err
+		}
+		// Weights change with every answer, so weighted games are kept too
+		if filtered || images.Weight != nil {
+			h.rounds[key] = round{questions: cloneQuestions(questions), answers: slices.Clone(answers), createdAt: time.Now()}
+		}
+	}
+
+	if player != "" {
+		seen := h.playerLocked(player)
+		for _, q := range questions {
+			for _, img := range q.Options {
+				seen.images[img] = true
+			}
+		}
+	}
+	return questions, answers, nil
+}
+
+// unseenLocked returns the images player has not seen yet, topped up with
+// seen ones picked by r where there are too few for a game. It reports
+// whether any image was left out. h.mu must be held.
+func (h *seenHistory) unseenLocked(r *rand.Rand, player string, images imageSet, count, options int) (imageSet, bool) {
+	seen := h.playerLocked(player)
+	if len(seen.images) == 0 {
+		return images, false
+	}
+	isSeen := func(img string) bool { return seen.images[img] }
+
+	var exhausted [3]bool
+	images.ChoiceA, exhausted[0] = preferUnseen(r, images.ChoiceA, count, isSeen)
+	images.ChoiceB, exhausted[1] = preferUnseen(r, images.ChoiceB, count*(options-1), isSeen)
+	if m := images.Manifest; m != nil {
+		curated := *m
+		curated.Questions, exhausted[2] = preferUnseen(r, m.Questions, count, func(q manifestQuestion) bool {
+			return seen.images[q.correctPath]
+		})
+		images.Manifest = &curated
+	}
+	if slices.Contains(exhausted[:], true) {
+		clear(seen.images)
+	}
+	return images, true
+}
+
+// preferUnseen returns the items of pool that are not seen, topped up to
+// need with seen ones picked by r. It reports whether the unseen items fell
+// short of need.
+func preferUnseen[T any](r *rand.Rand, pool []T, need int, seen func(T) bool) ([]T, bool) {
+	var unseen, rest []T
+	for _, item := range pool {
+		if seen(item) {
+			rest = append(rest, item)
+		} else {
+			unseen = append(unseen, item)
+		}
+	}
+	if len(unseen) >= need {
+		return unseen, false
+	}
+	r.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	return append(unseen, rest[:min(need-len(unseen), len(rest))]...), true
+}
+
+// playerLocked returns the history of player, creating it if needed;
+// h.mu must be held
+func (h *seenHistory) playerLocked(player string) *seenImages {
+	seen, ok := h.players[player]
+	if !ok {
+		seen = &seenImages{images: make(map[string]bool)}
+		h.players[player] = seen
+	}
+	seen.lastSeen = time.Now()
+	return seen
+}
+
+// pruneLocked forgets histories and rounds older than the TTL; h.mu must
+// be held
+func (h *seenHistory) pruneLocked() {
+	for player, seen := range h.players {
+		if time.Since(seen.lastSeen) > h.ttl {
+			delete(h.players, player)
+		}
+	}
+	for key, rd := range h.rounds {
+		if time.Since(rd.createdAt) > h.ttl {
+			delete(h.rounds, key)
+		}
+	}
+}
+
+// cloneQuestions copies questions so that publishing the images of one
+// game does not rewrite another
+func cloneQuestions(questions []Question) []Question {
+	clone := slices.Clone(questions)
+	for i := range clone {
+		clone[i].Options = slices.Clone(clone[i].Options)
+	}
+	return clone
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// historyImages returns an image set of n correct and n wrong images
+func historyImages(n int) imageSet {
+	var images imageSet
+	for i := 0; i < n; i++ {
+		images.ChoiceA = append(images.ChoiceA, fmt.Sprintf("a%d.jpg", i))
+		images.ChoiceB = append(images.ChoiceB, fmt.Sprintf("b%d.jpg", i))
+	}
+	return images
+}
+
+// optionsOf returns the images of all questions
+func optionsOf(questions []Question) []string {
+	var images []string
+	for _, q := range questions {
+		images = append(images, q.Options...)
+	}
+	return images
+}
+
+// TestSeenHistoryPrefersUnseen tests that consecutive games of a player do
+// not repeat images until the pool is exhausted
+func TestSeenHistoryPrefersUnseen(t *testing.T) {
+	h := newSeenHistory(time.Hour)
+	deck := &config.Deck{Name: config.DefaultDeck, QuestionCount: 2, OptionCount: 2}
+
+	first, _, err := h.questions("alice", deck, historyImages(4), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := h.questions("alice", deck, historyImages(4), 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range optionsOf(second) {
+		if slices.Contains(optionsOf(first), img) {
+			t.Errorf("Image %s is repeated in the second game", img)
+		}
+	}
+
+	// Every image has been seen, so the third game starts over
+	third, _, err := h.questions("alice", deck, historyImages(4), 3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(h.players["alice"].images); got != len(optionsOf(third)) {
+		t.Errorf("Expected the history to hold only the third game, got %d images", got)
+	}
+
+	// Other players have their own history
+	if _, _, err := h.questions("bob", deck, historyImages(4), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.players["bob"].images) != 4 {
+		t.Errorf("Unexpected history of bob: %v", h.players["bob"].images)
+	}
+}
+
+// TestSeenHistoryReplay tests that a seed replays a game even if its pool
+// was filtered by the history of another player
+func TestSeenHistoryReplay(t *testing.T) {
+	h := newSeenHistory(time.Hour)
+	deck := &config.Deck{Name: config.DefaultDeck, QuestionCount: 2, OptionCount: 2}
+
+	if _, _, err := h.questions("alice", deck, historyImages(6), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	filtered, answers, err := h.questions("alice", deck, historyImages(6), 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, replayedAnswers, err := h.questions("bob", deck, historyImages(6), 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(optionsOf(filtered), optionsOf(replayed)) || answers[0].Correct != replayedAnswers[0].Correct {
+		t.Errorf("Expected the same game, got %v and %v", optionsOf(filtered), optionsOf(replayed))
+	}
+
+	// A seed that is not remembered is built from every image
+	want, _, err := buildQuestions(newRand(7), historyImages(6), 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := h.questions("alice", deck, historyImages(6), 7, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(optionsOf(want), optionsOf(got)) {
+		t.Errorf("Expected %v, got %v", optionsOf(want), optionsOf(got))
+	}
+}
+
+// TestSeenHistoryPrune tests that idle histories are forgotten
+func TestSeenHistoryPrune(t *testing.T) {
+	h := newSeenHistory(time.Hour)
+	deck := &config.Deck{Name: config.DefaultDeck, QuestionCount: 1, OptionCount: 2}
+	if _, _, err := h.questions("alice", deck, historyImages(2), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	h.players["alice"].lastSeen = time.Now().Add(-2 * time.Hour)
+
+	if _, _, err := h.questions("bob", deck, historyImages(2), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := h.players["alice"]; ok {
+		t.Error("Expected the idle history to be pruned")
+	}
+}
+
+// TestPreferUnseen tests topping up unseen items with seen ones
+func TestPreferUnseen(t *testing.T) {
+	seen := func(s string) bool { return s[0] == 's' }
+	pool := []string{"s1", "u1", "s2", "u2"}
+
+	tests := []struct {
+		need      int
+		wantLen   int
+		exhausted bool
+	}{
+		{1, 2, false},
+		{2, 2, false},
+		{3, 3, true},
+		{5, 4, true},
+	}
+	for _, tt := range tests {
+		got, exhausted := preferUnseen(newRand(1), slices.Clone(pool), tt.need, seen)
+		if len(got) != tt.wantLen || exhausted != tt.exhausted || got[0] != "u1" || got[1] != "u2" {
+			t.Errorf("need %d: expected %d items exhausted %v, got %v %v", tt.need, tt.wantLen, tt.exhausted, got, exhausted)
+		}
+	}
+}
+
+// TestSeenHistoryAcrossLogins tests that a player logging in before each
+// game, as the frontend does, is not shown the images of their last game
+func TestSeenHistoryAcrossLogins(t *testing.T) {
+	withTestCatalog(t, 10, 10, 1)
+	savedHistory, savedLogins := history, logins
+	history, logins = newSeenHistory(time.Hour), newLoginLimiter()
+	t.Cleanup(func() { history, logins = savedHistory, savedLogins })
+	deck, _ := config.Env().Deck(config.DefaultDeck)
+
+	var cookies []*http.Cookie
+	play := func() []string {
+		t.Helper()
+		body, _ := json.Marshal(LoginRequest{Password: deck.APIAuth})
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		loginHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected login status 200, got %d", w.Code)
+		}
+		cookies = w.Result().Cookies()
+
+		req = httptest.NewRequest(http.MethodGet, "/game-data", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w = httptest.NewRecorder()
+		gameDataHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected game data status 200, got %d", w.Code)
+		}
+		var gameData GameData
+		if err := json.NewDecoder(w.Body).Decode(&gameData); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		var paths []string
+		for _, url := range optionsOf(gameData.Questions) {
+			path, ok := games.imagePath(config.DefaultDeck, strings.TrimPrefix(url, "/img/"))
+			if !ok {
+				t.Fatalf("Unknown image %s", url)
+			}
+			paths = append(paths, path)
+		}
+		return paths
+	}
+
+	first := play()
+	second := play()
+	if len(first) != 2*deck.QuestionCount || len(second) != 2*deck.QuestionCount {
+		t.Fatalf("Expected %d images per game, got %d and %d", 2*deck.QuestionCount, len(first), len(second))
+	}
+	for _, path := range second {
+		if slices.Contains(first, path) {
+			t.Errorf("Expected the second game not to repeat %s of the first", path)
+		}
+	}
+}
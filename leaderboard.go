@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// defaultLeaderboardSize is how many entries /leaderboard returns by default
+	defaultLeaderboardSize = 10
+	// maxLeaderboardSize caps the limit parameter of /leaderboard
+	maxLeaderboardSize = 100
+	// maxPlayerNameLength is the number of characters kept of a player name
+	maxPlayerNameLength = 24
+	// anonymousPlayer is recorded for players that did not enter a name
+	anonymousPlayer = "Anonymous"
+)
+
+// ScoreEntry is a completed game as recorded on the leaderboard
+type ScoreEntry struct {
+	Player      string    `json:"player"`
+	Deck        string    `json:"deck"`
+	Mode        string    `json:"mode,omitempty"` // speed, or empty for classic games
+	Correct     int       `json:"correct"`
+	Total       int       `json:"total"`
+	Won         bool      `json:"won"`
+	Points      int       `json:"points"`
+	TimeTakenMs int64     `json:"timeTakenMs"`
+	FinishedAt  time.Time `json:"finishedAt"`
+}
+
+// RankedScore is a leaderboard entry with its position
+type RankedScore struct {
+	Rank int `json:"rank"`
+	ScoreEntry
+}
+
+// LeaderboardResponse is the body of a GET to /leaderboard
+type LeaderboardResponse struct {
+	Deck    string        `json:"deck"`
+	Mode    string        `json:"mode,omitempty"`
+	Day     string        `json:"day,omitempty"`
+	Entries []RankedScore `json:"entries"`
+}
+
+// scoreboard keeps every completed game. When it has a path, each entry is
+// appended to that file as a line of JSON, so a crash loses at most the
+// entry being written.
+type scoreboard struct {
+	mu      sync.Mutex
+	path    string
+	entries []ScoreEntry
+}
+
+// scores records completed games; main replaces it with one backed by a file
+// in the data directory
+var scores = newScoreboard()
+
+// newScoreboard returns a scoreboard that is only kept in memory
+func newScoreboard() *scoreboard {
+	return &scoreboard{}
+}
+
+// openScoreboard loads the scoreboard saved at path, creating its directory
+// if needed. Lines that cannot be parsed, such as a line cut short by a
+// crash, are skipped.
+func openScoreboard(path string) (*scoreboard, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	b := &scoreboard{path: path}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var entry ScoreEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("Skipping invalid leaderboard entry %s:%d: %v\n", path, line, err)
+			continue
+		}
+		// Entries from before points were scored only counted answers
+		if entry.Points == 0 && entry.Mode == "" {
+			entry.Points = entry.Correct * pointsPerAnswer
+		}
+		b.entries = append(b.entries, entry)
+	}
+	return b, scanner.Err()
+}
+
+// record adds a completed game, writing it to disk before it is listed
+func (b *scoreboard) record(entry ScoreEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.path != "" {
+		if err := appendJSONLine(b.path, entry); err != nil {
+			return err
+		}
+	}
+	b.entries = append(b.entries, entry)
+	return nil
+}
+
+// appendJSONLine appends v to the file at path as a line of JSON and syncs
+// it to disk
+func appendJSONLine(path string, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// top returns the best entries of a deck and game mode, optionally only
+// those finished on the given day. More points rank higher, ties go to the
+// faster and then the earlier game.
+func (b *scoreboard) top(deck, mode string, day time.Time, limit int) []RankedScore {
+	b.mu.Lock()
+	var matches []ScoreEntry
+	for _, e := range b.entries {
+		if e.Deck == deck && e.Mode == mode && (day.IsZero() || sameDay(e.FinishedAt, day)) {
+			matches = append(matches, e)
+		}
+	}
+	b.mu.Unlock()
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		x, y := matches[i], matches[j]
+		if x.Points != y.Points {
+			return x.Points > y.Points
+		}
+		if x.TimeTakenMs != y.TimeTakenMs {
+			return x.TimeTakenMs < y.TimeTakenMs
+		}
+		return x.FinishedAt.Before(y.FinishedAt)
+	})
+
+	ranked := make([]RankedScore, 0, min(limit, len(matches)))
+	for i := 0; i < len(matches) && i < limit; i++ {
+		ranked = append(ranked, RankedScore{Rank: i + 1, ScoreEntry: matches[i]})
+	}
+	return ranked
+}
+
+// sameDay reports whether t falls on the calendar day of day in day's location
+func sameDay(t, day time.Time) bool {
+	y1, m1, d1 := t.In(day.Location()).Date()
+	y2, m2, d2 := day.Date()
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
+
+// playerName cleans up a name entered by a player
+func playerName(name string) string {
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}), " ")
+	if runes := []rune(name); len(runes) > maxPlayerNameLength {
+		name = string(runes[:maxPlayerNameLength])
+	}
+	if name == "" {
+		return anonymousPlayer
+	}
+	return name
+}
+
+// leaderboardHandler serves the best games of the requested deck. The
+// optional limit parameter sets the number of entries, day=YYYY-MM-DD
+// or day=today restricts them to one day in server time and mode=speed
+// lists speed games instead of classic ones.
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := defaultLeaderboardSize
+	if val := r.URL.Query().Get("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 || n > maxLeaderboardSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxLeaderboardSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var day time.Time
+	switch val := r.URL.Query().Get("day"); val {
+	case "":
+	case "today":
+		day = time.Now()
+	default:
+		var err error
+		if day, err = time.ParseInLocation(time.DateOnly, val, time.Local); err != nil {
+			http.Error(w, "day must be a date like 2006-01-02", http.StatusBadRequest)
+			return
+		}
+	}
+
+	mode, err := parseMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deck := deckOf(r).Name
+	resp := LeaderboardResponse{Deck: deck, Mode: mode, Entries: scores.top(deck, mode, day, limit)}
+	if !day.IsZero() {
+		resp.Day = day.Format(time.DateOnly)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		respondWithError(w, "Could not encode leaderboard", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// TestScoreboardPersists tests that recorded games survive reopening
+func TestScoreboardPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "leaderboard.jsonl")
+	b, err := openScoreboard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finished := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, player := range []string{"Alice", "Bob"} {
+		if err := b.record(ScoreEntry{Player: player, Deck: "alice", Correct: 2, Total: 3, FinishedAt: finished}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An entry from before points were scored counts its answers, and a
+	// line cut short by a crash is skipped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"player":"Dave","deck":"alice","correct":3,"total":3,"finishedAt":"2026-05-01T12:00:00Z"}` + "\n")
+	f.WriteString(`{"player":"Car`)
+	f.Close()
+
+	b, err = openScoreboard(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := b.top("alice", "", time.Time{}, 10)
+	if len(top) != 3 || top[0].Player != "Dave" || top[0].Points != 3*pointsPerAnswer {
+		t.Fatalf("Expected the legacy entry to rank first, got %+v", top)
+	}
+	if top[1].Player != "Alice" || !top[1].FinishedAt.Equal(finished) {
+		t.Errorf("Unexpected entries after reopening: %+v", top)
+	}
+}
+
+// TestScoreboardTop tests ranking, deck, mode and day filters and the limit
+func TestScoreboardTop(t *testing.T) {
+	day := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	b := newScoreboard()
+	for _, e := range []ScoreEntry{
+		{Player: "slow", Deck: config.DefaultDeck, Correct: 5, Points: 5000, TimeTakenMs: 9000, FinishedAt: day.Add(time.Hour)},
+		{Player: "fast", Deck: config.DefaultDeck, Correct: 5, Points: 5000, TimeTakenMs: 3000, FinishedAt: day.Add(2 * time.Hour)},
+		{Player: "wrong", Deck: config.DefaultDeck, Correct: 1, Points: 1000, TimeTakenMs: 1000, FinishedAt: day.Add(3 * time.Hour)},
+		{Player: "yesterday", Deck: config.DefaultDeck, Correct: 5, Points: 5000, TimeTakenMs: 1000, FinishedAt: day.Add(-time.Hour)},
+		{Player: "other deck", Deck: "alice", Correct: 5, Points: 5000, TimeTakenMs: 1000, FinishedAt: day.Add(time.Hour)},
+		{Player: "quick", Deck: config.DefaultDeck, Mode: modeSpeed, Correct: 4, Points: 3900, TimeTakenMs: 9000, FinishedAt: day.Add(time.Hour)},
+		{Player: "quicker", Deck: config.DefaultDeck, Mode: modeSpeed, Correct: 4, Points: 3950, TimeTakenMs: 9500, FinishedAt: day.Add(time.Hour)},
+	} {
+		if err := b.record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		mode  string
+		day   time.Time
+		limit int
+		want  []string
+	}{
+		{"all time", "", time.Time{}, 10, []string{"yesterday", "fast", "slow", "wrong"}},
+		{"limited", "", time.Time{}, 2, []string{"yesterday", "fast"}},
+		{"one day", "", day, 10, []string{"fast", "slow", "wrong"}},
+		{"empty day", "", day.AddDate(0, 0, 1), 10, nil},
+		{"speed", modeSpeed, time.Time{}, 10, []string{"quicker", "quick"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top := b.top(config.DefaultDeck, tt.mode, tt.day, tt.limit)
+			if len(top) != len(tt.want) {
+				t.Fatalf("Expected %d entries, got %+v", len(tt.want), top)
+			}
+			for i, player := range tt.want {
+				if top[i].Player != player || top[i].Rank != i+1 {
+					t.Errorf("Expected %s at rank %d, got %+v", player, i+1, top[i])
+				}
+			}
+		})
+	}
+}
+
+// TestPlayerName tests the clean-up of entered player names
+func TestPlayerName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"  Alice  ", "Alice"},
+		{"Alice\n\tBob", "Alice Bob"},
+		{"", anonymousPlayer},
+		{"\x00\x07", anonymousPlayer},
+		{"Ｗｉｌｈｅｌｍｉｎａ Ｃｈａｒｌｏｔｔｅ Ｂｅａｔｒｉｘ", "Ｗｉｌｈｅｌｍｉｎａ Ｃｈａｒｌｏｔｔｅ Ｂｅａ"},
+	}
+
+	for _, tt := range tests {
+		if got := playerName(tt.input); got != tt.expected {
+			t.Errorf("playerName(%q): expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// TestLeaderboardHandler tests that finished games show up on the
+// leaderboard, except for replays of a requested seed
+func TestLeaderboardHandler(t *testing.T) {
+	saved := scores
+	scores = newScoreboard()
+	defer func() { scores = saved }()
+
+	g, err := games.create(config.DefaultDeck, "Alice", 0, false, twoWay(0, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := games.create(config.DefaultDeck, "Mallory", 0, true, twoWay(0, 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, option := range []int{0, 0} {
+		body, _ := json.Marshal(AnswerRequest{GameID: g.id, Question: i, Option: &option})
+		answerHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/answer", bytes.NewReader(body)))
+	}
+	for i, option := range []int{0, 1} {
+		body, _ := json.Marshal(AnswerRequest{GameID: replay.id, Question: i, Option: &option})
+		answerHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/answer", bytes.NewReader(body)))
+	}
+
+	w := httptest.NewRecorder()
+	leaderboardHandler(w, httptest.NewRequest(http.MethodGet, "/leaderboard?day=today&limit=5", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var resp LeaderboardResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Deck != config.DefaultDeck || resp.Day != time.Now().Format(time.DateOnly) {
+		t.Errorf("Unexpected leaderboard: %+v", resp)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].Player != "Alice" || resp.Entries[0].Correct != 1 || resp.Entries[0].Won {
+		t.Errorf("Unexpected entries: %+v", resp.Entries)
+	}
+}
+
+// TestLeaderboardHandlerBadRequests tests invalid leaderboard queries
+func TestLeaderboardHandlerBadRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		status int
+	}{
+		{"wrong method", http.MethodPost, "/leaderboard", http.StatusMethodNotAllowed},
+		{"limit not a number", http.MethodGet, "/leaderboard?limit=ten", http.StatusBadRequest},
+		{"limit too large", http.MethodGet, "/leaderboard?limit=1000", http.StatusBadRequest},
+		{"invalid day", http.MethodGet, "/leaderboard?day=yesterday", http.StatusBadRequest},
+		{"unknown mode", http.MethodGet, "/leaderboard?mode=slow", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			leaderboardHandler(w, httptest.NewRequest(tt.method, tt.url, nil))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
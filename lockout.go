@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// loginFreeAttempts is how many failures a client gets before backoff
+	loginFreeAttempts = 3
+	// loginBaseDelay is the first backoff, doubled with every further failure
+	loginBaseDelay = time.Second
+	// loginMaxDelay caps the backoff, which then acts as a lockout
+	loginMaxDelay = 15 * time.Minute
+	// loginForgetAfter resets a client's failures after a quiet period
+	loginForgetAfter = time.Hour
+)
+
+// loginKey identifies whose failures count together: a client IP guessing
+// the password of one deck. Logging in to another deck does not reset them.
+type loginKey struct {
+	client string
+	deck   string
+}
+
+// loginAttempts is the failure history of a single client and deck
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// loginLimiter throttles password guessing per client IP and deck with
+// exponential backoff
+type loginLimiter struct {
+	mu      sync.Mutex
+	clients map[loginKey]*loginAttempts
+	now     func() time.Time
+}
+
+var logins = newLoginLimiter()
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{
+		clients: make(map[loginKey]*loginAttempts),
+		now:     time.Now,
+	}
+}
+
+// loginResult is the outcome of a login attempt
+type loginResult struct {
+	allowed  bool          // false if the client is blocked
+	wait     time.Duration // how long a blocked client has to wait
+	failures int           // failures of the client, including a wrong password
+	blocked  time.Duration // how long a wrong password blocks the client for
+}
+
+// attempt settles a login attempt of a client to a deck whose password
+// matched or not. Checking for a block and recording the outcome happen in
+// one step, so that parallel attempts cannot get past the block one of them
+// starts. A blocked client is refused even with the right password;
+// otherwise a match forgets the client's failures for that deck only and a
+// wrong password counts as one.
+func (l *loginLimiter) attempt(client, deck string, matched bool) loginResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := loginKey{client: client, deck: deck}
+	now := l.now()
+	if a, ok := l.clients[key]; ok {
+		if wait := a.blockedUntil.Sub(now); wait > 0 {
+			return loginResult{wait: wait}
+		}
+	}
+	if matched {
+		delete(l.clients, key)
+		return loginResult{allowed: true}
+	}
+
+	l.pruneLocked(now)
+	a, ok := l.clients[key]
+	if !ok {
+		a = &loginAttempts{}
+		l.clients[key] = a
+	}
+	a.failures++
+	a.lastFailure = now
+
+	if a.failures < loginFreeAttempts {
+		return loginResult{allowed: true, failures: a.failures}
+	}
+	delay := loginMaxDelay
+	if shift := a.failures - loginFreeAttempts; shift < 30 {
+		delay = min(loginBaseDelay<<shift, loginMaxDelay)
+	}
+	a.blockedUntil = now.Add(delay)
+	return loginResult{allowed: true, failures: a.failures, blocked: delay}
+}
+
+// pruneLocked forgets clients that have been quiet for a while; l.mu must be held
+func (l *loginLimiter) pruneLocked(now time.Time) {
+	for key, a := range l.clients {
+		if now.Sub(a.lastFailure) > loginForgetAfter && now.After(a.blockedUntil) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// tooManyLogins refuses a blocked client, telling it how long to wait
+func tooManyLogins(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+}
+
+// clientIP returns the IP address of the connection. Forwarding headers are
+// ignored since they can be set freely by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// passwordMatches compares passwords in constant time. Hashing first keeps
+// the comparison from leaking the length of the expected password.
+func passwordMatches(given, want string) bool {
+	g := sha256.Sum256([]byte(given))
+	w := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(g[:], w[:]) == 1
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// TestLoginLimiterBackoff tests that failures lead to growing lockouts
+func TestLoginLimiterBackoff(t *testing.T) {
+	now := time.Now()
+	l := newLoginLimiter()
+	l.now = func() time.Time { return now }
+
+	for i := 1; i < loginFreeAttempts; i++ {
+		if r := l.attempt("1.2.3.4", "alice", false); !r.allowed || r.failures != i || r.blocked != 0 {
+			t.Errorf("Expected free attempt %d, got %+v", i, r)
+		}
+	}
+
+	var last time.Duration
+	for i := 0; i < 4; i++ {
+		now = now.Add(last)
+		r := l.attempt("1.2.3.4", "alice", false)
+		if !r.allowed || r.blocked <= last {
+			t.Errorf("Expected backoff to grow, got %+v after %s", r, last)
+		}
+		last = r.blocked
+	}
+
+	if r := l.attempt("1.2.3.4", "alice", true); r.allowed || r.wait != last {
+		t.Errorf("Expected client to wait %s even with the right password, got %+v", last, r)
+	}
+	if r := l.attempt("5.6.7.8", "alice", false); !r.allowed {
+		t.Error("Expected other clients to be unaffected")
+	}
+
+	now = now.Add(last)
+	if r := l.attempt("1.2.3.4", "alice", true); !r.allowed {
+		t.Error("Expected client to be allowed after the backoff")
+	}
+}
+
+// TestLoginLimiterParallel tests that parallel attempts cannot get past the
+// block that one of them starts
+func TestLoginLimiterParallel(t *testing.T) {
+	l := newLoginLimiter()
+
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.attempt("1.2.3.4", "alice", false).allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != loginFreeAttempts {
+		t.Errorf("Expected %d attempts to be checked, got %d", loginFreeAttempts, got)
+	}
+}
+
+// TestLoginLimiterCap tests that the backoff is capped
+func TestLoginLimiterCap(t *testing.T) {
+	now := time.Now()
+	l := newLoginLimiter()
+	l.now = func() time.Time { return now }
+
+	var blocked time.Duration
+	for i := 0; i < 100; i++ {
+		now = now.Add(blocked)
+		blocked = l.attempt("1.2.3.4", "alice", false).blocked
+	}
+	if blocked != loginMaxDelay {
+		t.Errorf("Expected backoff capped at %s, got %s", loginMaxDelay, blocked)
+	}
+}
+
+// TestLoginLimiterSucceedAndForget tests resetting failures
+func TestLoginLimiterSucceedAndForget(t *testing.T) {
+	now := time.Now()
+	l := newLoginLimiter()
+	l.now = func() time.Time { return now }
+
+	l.attempt("1.2.3.4", "alice", false)
+	l.attempt("1.2.3.4", "alice", true)
+	if r := l.attempt("1.2.3.4", "alice", false); r.failures != 1 {
+		t.Errorf("Expected failures to restart after success, got %d", r.failures)
+	}
+
+	now = now.Add(loginForgetAfter + time.Minute)
+	l.attempt("5.6.7.8", "alice", false)
+	if _, ok := l.clients[loginKey{"1.2.3.4", "alice"}]; ok {
+		t.Error("Expected quiet client to be forgotten")
+	}
+}
+
+// TestLoginHandlerLockout tests the 429 response with Retry-After
+func TestLoginHandlerLockout(t *testing.T) {
+	logins = newLoginLimiter()
+	defer func() { logins = newLoginLimiter() }()
+
+	for i := 0; i < loginFreeAttempts; i++ {
+		w := httptest.NewRecorder()
+		loginHandler(w, httptest.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(`{"password":"guess"}`)))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401 on attempt %d, got %d", i+1, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	loginHandler(w, httptest.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(`{"password":"guess"}`)))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	retry, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retry < 1 {
+		t.Errorf("Expected a positive Retry-After, got %q", w.Header().Get("Retry-After"))
+	}
+}
+
+// loginRequest builds a login to deck with password
+func loginRequest(deck *config.Deck, password string) *http.Request {
+	body, _ := json.Marshal(LoginRequest{Password: password})
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+	return req.WithContext(context.WithValue(req.Context(), deckContextKey, deck))
+}
+
+// TestLoginHandlerLockoutPerDeck tests that logging in to one deck does not
+// reset the failures of guessing the password of another
+func TestLoginHandlerLockoutPerDeck(t *testing.T) {
+	logins = newLoginLimiter()
+	defer func() { logins = newLoginLimiter() }()
+	alice := &config.Deck{Name: "alice", APIAuth: "alice-pw"}
+	bob := &config.Deck{Name: "bob", APIAuth: "bob-pw"}
+
+	for i := 0; i < loginFreeAttempts; i++ {
+		w := httptest.NewRecorder()
+		loginHandler(w, loginRequest(alice, "alice-pw"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected login to alice to succeed, got %d", w.Code)
+		}
+		w = httptest.NewRecorder()
+		loginHandler(w, loginRequest(bob, "guess"))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected status 401 on guess %d, got %d", i+1, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	loginHandler(w, loginRequest(bob, "guess"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected guesses at bob to be blocked, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	loginHandler(w, loginRequest(alice, "alice-pw"))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected alice to be unaffected, got %d", w.Code)
+	}
+}
+
+// TestPasswordMatches tests the constant-time comparison
+func TestPasswordMatches(t *testing.T) {
+	tests := []struct {
+		given, want string
+		match       bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"secret", "secret ", false},
+		{"", "", true},
+		{"", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := passwordMatches(tt.given, tt.want); got != tt.match {
+			t.Errorf("passwordMatches(%q, %q) = %v, want %v", tt.given, tt.want, got, tt.match)
+		}
+	}
+}
+
+// TestClientIP tests extracting the client address
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	if got := clientIP(req); got != "203.0.113.7" {
+		t.Errorf("Expected 203.0.113.7, got %s", got)
+	}
+
+	req.RemoteAddr = "[2001:db8::1]:443"
+	if got := clientIP(req); got != "2001:db8::1" {
+		t.Errorf("Expected 2001:db8::1, got %s", got)
+	}
+}
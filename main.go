@@ -2,27 +2,56 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
+	"syscall"
 
 	"whos-your-mate/config"
 )
 
+// gameDataVersion is raised whenever GameData or the answer protocol change
+// incompatibly, so that clients can tell which contract they talk to.
+// Version 1 had img1/img2 questions answered with choice 1 or 2, version 2
+// sent the ending photo upfront instead of with the result.
+const gameDataVersion = 3
+
+// maxSeed is the largest game seed. Seeds stay within the integers a
+// JavaScript number holds exactly, so that clients can pass them on.
+const maxSeed = 1<<53 - 1
+
+// newSeed returns the seed of a game that did not ask for a specific one
+var newSeed = func() int64 { return rand.Int63n(maxSeed + 1) }
+
+// Question is sent to the client; the correct option stays on the server and
+// images are referenced by opaque per-game URLs
 type Question struct {
-	Img1    string `json:"img1"`
-	Img2    string `json:"img2"`
-	Correct int    `json:"correct"` // 1 or 2 indicating the correct option
+	Options    []string `json:"options"`
+	Caption    string   `json:"caption,omitempty"`
+	Difficulty string   `json:"difficulty,omitempty"`
 }
 
+// GameData starts a game. Speed games leave out the questions, which are
+// asked one at a time through /next.
 type GameData struct {
-	Questions   []Question `json:"questions"`
-	EndingPhoto string     `json:"endingPhoto"`
+	Version       int        `json:"version"`
+	GameID        string     `json:"gameId"`
+	Seed          int64      `json:"seed"` // requesting the same seed replays the same questions
+	Mode          string     `json:"mode,omitempty"`
+	QuestionCount int        `json:"questionCount"`
+	TimeLimitMs   int64      `json:"timeLimitMs,omitempty"` // per question of a speed game
+	Questions     []Question `json:"questions,omitempty"`
 }
 
 var supportExtensions = map[string]bool{
@@ -30,63 +59,195 @@ var supportExtensions = map[string]bool{
 }
 
 func main() {
-	http.Handle("/", http.FileServer(http.Dir(config.Env().StaticDir)))
-	http.Handle("/images/", corsMiddleware(http.StripPrefix("/images/", http.FileServer(http.Dir(config.Env().ImagesDir)))))
-	http.Handle("/game-data", corsMiddleware(http.HandlerFunc(gameDataHandler)))
-	log.Printf("Server started at %d\n", config.Env().Port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", config.Env().Port), nil))
-}
+	if len(os.Args) > 1 && os.Args[1] == "stats" {
+		if err := statsCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-// corsMiddleware adds CORS headers and checks authorization
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
+	config.RegisterFlags(flag.CommandLine)
+	checkOnly := flag.Bool("check", false, "validate the configuration and image directories, then exit")
+	flag.Parse()
+	if err := config.Init(flag.CommandLine); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	// Decks can be filled through the admin upload once it is enabled
+	uploads := config.Env().AdminAuth != ""
+	report := checkDecks(config.Env().Decks, uploads)
+	if *checkOnly {
+		fmt.Print(report)
+		if !report.OK() {
+			os.Exit(1)
 		}
-		if r.URL.Query().Get("auth") != config.Env().APIAuth {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		return
+	}
+	if !report.OK() {
+		log.Fatalf("Image directories are not ready:\n%s", report)
+	}
+	for _, issue := range report.Issues {
+		log.Println("Self-check warning:", issue.Msg)
+	}
+
+	secret := []byte(config.Env().SessionSecret)
+	if len(secret) == 0 {
+		log.Println("SESSION_SECRET is not set, sessions will not survive a restart")
+		secret = randomSecret()
+	}
+	sessions = newSessionSigner(secret, config.Env().SessionTTL)
+	history = newSeenHistory(config.Env().SessionTTL)
+	cors = newCORSPolicy(config.Env().CORSOrigins, config.Env().CORSCredentials)
+	adminAuth = config.Env().AdminAuth
+	maxUploadSize = int64(config.Env().MaxUploadMB) << 20
+
+	board, err := openScoreboard(filepath.Join(config.Env().DataDir, "leaderboard.jsonl"))
+	if err != nil {
+		log.Fatalf("Could not open the leaderboard: %v", err)
+	}
+	scores = board
+	answered, err := openStatsLog(filepath.Join(config.Env().DataDir, "answers.jsonl"))
+	if err != nil {
+		log.Fatalf("Could not open the image statistics: %v", err)
+	}
+	stats = answered
+	rotated, err := openPasswordStore(filepath.Join(config.Env().DataDir, "passwords.json"))
+	if err != nil {
+		log.Fatalf("Could not open the rotated passwords: %v", err)
+	}
+	passwords = rotated
+	variants = newVariantCache(filepath.Join(config.Env().DataDir, "variants"))
+
+	catalogs = make(map[string]*imageCatalog)
+	for _, deck := range config.Env().Decks {
+		if uploads {
+			createImageDirs(deck)
 		}
-		next.ServeHTTP(w, r)
-	})
+		c := newImageCatalog(deck.ChoiceAImgDir, deck.ChoiceBImgDir, deck.EndingImgDir, deck.Manifest)
+		if err := c.Refresh(); err != nil {
+			log.Printf("Could not index images of deck %s: %v\n", deck.Name, err)
+		}
+		rescan := make(chan os.Signal, 1)
+		signal.Notify(rescan, syscall.SIGHUP)
+		go c.Watch(config.Env().RescanInterval, rescan, nil)
+		catalogs[deck.Name] = c
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(config.Env().StaticDir)))
+	mux.Handle("/deck", http.HandlerFunc(deckHandler))
+	mux.Handle("/login", http.HandlerFunc(loginHandler))
+	mux.Handle("/logout", http.HandlerFunc(logoutHandler))
+	mux.Handle("/img/", requireSession(http.HandlerFunc(imageHandler)))
+	mux.Handle("/game-data", requireSession(http.HandlerFunc(gameDataHandler)))
+	mux.Handle("/next", requireSession(http.HandlerFunc(nextHandler)))
+	mux.Handle("/answer", requireSession(http.HandlerFunc(answerHandler)))
+	mux.Handle("/leaderboard", requireSession(http.HandlerFunc(leaderboardHandler)))
+	mux.Handle("/party/create", requireSession(http.HandlerFunc(partyCreateHandler)))
+	mux.Handle("/party/join", requireSession(http.HandlerFunc(partyJoinHandler)))
+	mux.Handle("/party/start", requireSession(http.HandlerFunc(partyStartHandler)))
+	mux.Handle("/party/next", requireSession(http.HandlerFunc(partyNextHandler)))
+	mux.Handle("/party/answer", requireSession(http.HandlerFunc(partyAnswerHandler)))
+	mux.Handle("/party/events", requireSession(http.HandlerFunc(partyEventsHandler)))
+	mux.Handle("/present", requireSession(http.HandlerFunc(presentHandler)))
+	mux.Handle("/admin/", requireAdmin(consoleHandler()))
+	mux.Handle("/admin/decks", requireAdmin(http.HandlerFunc(adminDecksHandler)))
+	mux.Handle("/admin/preview", requireAdmin(http.HandlerFunc(adminPreviewHandler)))
+	mux.Handle("/admin/image", requireAdmin(http.HandlerFunc(adminImageFileHandler)))
+	mux.Handle("/admin/password", requireAdmin(http.HandlerFunc(adminPasswordHandler)))
+	mux.Handle("/admin/stats", requireAdmin(http.HandlerFunc(adminStatsHandler)))
+	mux.Handle("/admin/images", requireAdmin(http.HandlerFunc(adminImagesHandler)))
+	mux.Handle("/admin/images/move", requireAdmin(http.HandlerFunc(adminMoveImageHandler)))
+	log.Printf("Server started at %d\n", config.Env().Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", config.Env().Port), withDeck(cors.handler(mux))))
 }
 
-// gameDataHandler serves randomized game data as JSON
+// gameDataHandler serves randomized game data as JSON. With mode=speed it
+// starts a speed game, whose questions are asked through /next.
 func gameDataHandler(w http.ResponseWriter, r *http.Request) {
-	correctImages, err := loadImages(config.Env().ChoiceAImgDir)
+	deck := deckOf(r)
+	c, ok := catalogs[deck.Name]
+	if !ok {
+		respondWithError(w, "Deck has no images", fmt.Errorf("no catalog for deck %s", deck.Name))
+		return
+	}
+	images := c.Snapshot()
+
+	if len(images.Ending) == 0 {
+		respondWithError(w, "Not enough images to create questions", errors.New("Not enough images. Ending Images: 0"))
+		return
+	}
+	requested := r.URL.Query().Get("seed")
+	seed, err := parseSeed(requested)
 	if err != nil {
-		respondWithError(w, "Could not read your images", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	wrongImages, err := loadImages(config.Env().ChoiceBImgDir)
+	difficulty, err := parseDifficulty(r.URL.Query().Get("difficulty"))
 	if err != nil {
-		respondWithError(w, "Could not read celebrity images", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	endingPhotos, err := loadImages(config.Env().EndingImgDir)
+	mode, err := parseMode(r.URL.Query().Get("mode"))
 	if err != nil {
-		respondWithError(w, "Could not read ending images", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	questionCount := config.Env().QuestionCount
-	if len(correctImages) < questionCount || len(wrongImages) < questionCount || len(endingPhotos) == 0 {
-		err := fmt.Errorf("Not enough images. Correct Images: %d, Wrong Images: %d, Ending Images: %d", len(correctImages), len(wrongImages), len(endingPhotos))
+	if mode == modeSpeed && deck.QuestionTime == 0 {
+		http.Error(w, "Speed games are disabled", http.StatusBadRequest)
+		return
+	}
+	images.Weight = stats.weight(deck.Name, difficulty)
+	// Players are told apart by their session for the history of seen images
+	session, _ := sessionFrom(r)
+	questions, answers, err := history.questions(session.ID, deck, images, seed, requested != "")
+	if err != nil {
 		respondWithError(w, "Not enough images to create questions", err)
 		return
 	}
+	images.describe(questions, answers)
 
-	questions := generateQuestions(correctImages, wrongImages, questionCount)
-	endingPhoto := "/" + endingPhotos[randomIndex(len(endingPhotos))]
+	player := playerName(r.URL.Query().Get("player"))
+	if mode == modeSpeed {
+		game, err := games.createTimed(deck.Name, player, seed, requested != "", questions, answers, images.Endings, deck.QuestionTime)
+		if err != nil {
+			respondWithError(w, "Could not create game", err)
+			return
+		}
+		publishSolo(deck.Name, "started", SoloStarted{Player: player, Mode: mode, QuestionCount: len(questions)})
+		writeGameData(w, GameData{
+			Version:       gameDataVersion,
+			GameID:        game.id,
+			Seed:          seed,
+			Mode:          mode,
+			QuestionCount: len(questions),
+			TimeLimitMs:   deck.QuestionTime.Milliseconds(),
+		})
+		return
+	}
 
-	gameData := GameData{
-		Questions:   questions,
-		EndingPhoto: endingPhoto,
+	game, err := games.create(deck.Name, player, seed, requested != "", answers, images.Endings)
+	if err != nil {
+		respondWithError(w, "Could not create game", err)
+		return
+	}
+	if err := games.publishQuestions(game, questions); err != nil {
+		respondWithError(w, "Could not publish images", err)
+		return
 	}
 
+	publishSolo(deck.Name, "started", SoloStarted{Player: player, QuestionCount: len(questions)})
+	writeGameData(w, GameData{
+		Version:       gameDataVersion,
+		GameID:        game.id,
+		Seed:          seed,
+		QuestionCount: len(questions),
+		Questions:     questions,
+	})
+}
+
+// writeGameData sends the data of a new game
+func writeGameData(w http.ResponseWriter, gameData GameData) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(gameData); err != nil {
 		respondWithError(w, "Could not encode game data", err)
@@ -112,42 +273,73 @@ func loadImages(dir string) ([]string, error) {
 	return images, err
 }
 
-// generateQuestions creates randomized questions for the game
-func generateQuestions(correctImages, wrongImagesImages []string, count int) []Question {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	r.Shuffle(len(correctImages), func(i, j int) {
-		correctImages[i], correctImages[j] = correctImages[j], correctImages[i]
-	})
-	r.Shuffle(len(wrongImagesImages), func(i, j int) {
-		wrongImagesImages[i], wrongImagesImages[j] = wrongImagesImages[j], wrongImagesImages[i]
-	})
+// generateQuestions creates randomized questions for the game along with
+// their answer keys. Every question shows one correct image and
+// options-1 wrong images at random positions; no image is used twice. The
+// same source state and images always produce the same questions. With a
+// weight, images are drawn with a probability proportional to it.
+func generateQuestions(r *rand.Rand, correctImages, wrongImages []string, count, options int, weight func(img string) float64) ([]Question, []answerKey) {
+	if weight != nil {
+		weightedShuffle(r, correctImages, weight)
+		weightedShuffle(r, wrongImages, weight)
+	} else {
+		r.Shuffle(len(correctImages), func(i, j int) {
+			correctImages[i], correctImages[j] = correctImages[j], correctImages[i]
+		})
+		r.Shuffle(len(wrongImages), func(i, j int) {
+			wrongImages[i], wrongImages[j] = wrongImages[j], wrongImages[i]
+		})
+	}
 
+	distractors := options - 1
 	questions := make([]Question, count)
+	answers := make([]answerKey, count)
 	for i := 0; i < count; i++ {
-		correctOption := r.Intn(2) + 1 // 1 or 2
-		if correctOption == 1 {
-			questions[i] = Question{
-				Img1:    "/" + correctImages[i],
-				Img2:    "/" + wrongImagesImages[i],
-				Correct: 1,
-			}
-		} else {
-			questions[i] = Question{
-				Img1:    "/" + wrongImagesImages[i],
-				Img2:    "/" + correctImages[i],
-				Correct: 2,
-			}
-		}
+		correct := r.Intn(options)
+		opts := make([]string, 0, options)
+		opts = append(opts, wrongImages[i*distractors:(i+1)*distractors]...)
+		opts = slices.Insert(opts, correct, correctImages[i])
+		questions[i] = Question{Options: opts}
+		answers[i] = answerKey{Correct: correct, Options: options}
+	}
+	return questions, answers
+}
+
+// weightedShuffle orders images so that any prefix is a weighted random
+// sample without replacement: each image is keyed by u^(1/weight) for a
+// uniform u and the highest keys come first. Weights must be positive.
+func weightedShuffle(r *rand.Rand, images []string, weight func(img string) float64) {
+	keys := make(map[string]float64, len(images))
+	for _, img := range images {
+		keys[img] = math.Pow(r.Float64(), 1/weight(img))
 	}
-	return questions
+	sort.SliceStable(images, func(i, j int) bool { return keys[images[i]] > keys[images[j]] })
 }
 
 // randomIndex returns a random index for a slice of given length
-func randomIndex(length int) int {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+func randomIndex(r *rand.Rand, length int) int {
 	return r.Intn(length)
 }
 
+// newRand returns a random source that replays the same sequence for the
+// same seed
+func newRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// parseSeed returns the seed requested by a client, or a new one if none
+// was requested
+func parseSeed(s string) (int64, error) {
+	if s == "" {
+		return newSeed(), nil
+	}
+	seed, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || seed < 0 || seed > maxSeed {
+		return 0, fmt.Errorf("seed must be an integer between 0 and %d", int64(maxSeed))
+	}
+	return seed, nil
+}
+
 // respondWithError logs the error and sends an HTTP error response
 func respondWithError(w http.ResponseWriter, msg string, err error) {
 	http.Error(w, msg, http.StatusInternalServerError)
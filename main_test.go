@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -9,67 +10,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
-)
-
-// TestCorsMiddleware tests the CORS middleware functionality
-func TestCorsMiddleware(t *testing.T) {
-	tests := []struct {
-		name            string
-		method          string
-		auth            string
-		expectedStatus  int
-		expectedHeaders map[string]string
-	}{
-		{
-			name:           "OPTIONS request should return 200",
-			method:         "OPTIONS",
-			auth:           "",
-			expectedStatus: http.StatusOK,
-			expectedHeaders: map[string]string{
-				"Access-Control-Allow-Methods": "GET, OPTIONS",
-				"Access-Control-Allow-Headers": "Content-Type, Authorization",
-			},
-		},
-		{
-			name:           "Unauthorized request should return 401",
-			method:         "GET",
-			auth:           "wrong-auth",
-			expectedStatus: http.StatusUnauthorized,
-			expectedHeaders: map[string]string{
-				"Access-Control-Allow-Methods": "GET, OPTIONS",
-				"Access-Control-Allow-Headers": "Content-Type, Authorization",
-			},
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(tt.method, "/test", nil)
-			if tt.auth != "" {
-				q := req.URL.Query()
-				q.Add("auth", tt.auth)
-				req.URL.RawQuery = q.Encode()
-			}
-
-			w := httptest.NewRecorder()
-			handler := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusOK)
-			}))
-
-			handler.ServeHTTP(w, req)
-
-			if w.Code != tt.expectedStatus {
-				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
-			}
+	"time"
 
-			for header, expectedValue := range tt.expectedHeaders {
-				if got := w.Header().Get(header); got != expectedValue {
-					t.Errorf("Expected header %s to be %s, got %s", header, expectedValue, got)
-				}
-			}
-		})
-	}
-}
+	"whos-your-mate/config"
+)
 
 // TestLoadImages tests the loadImages function
 func TestLoadImages(t *testing.T) {
@@ -126,34 +70,45 @@ func TestLoadImagesNonexistentDir(t *testing.T) {
 
 // TestGenerateQuestions tests the generateQuestions function
 func TestGenerateQuestions(t *testing.T) {
-	correctImages := []string{"correct1.jpg", "correct2.jpg", "correct3.jpg"}
-	wrongImages := []string{"wrong1.jpg", "wrong2.jpg", "wrong3.jpg"}
-	count := 3
-
-	questions := generateQuestions(correctImages, wrongImages, count)
+	for _, options := range []int{2, 4} {
+		t.Run(fmt.Sprintf("%d options", options), func(t *testing.T) {
+			count := 3
+			correctImages := make([]string, count)
+			for i := range correctImages {
+				correctImages[i] = fmt.Sprintf("correct%d.jpg", i)
+			}
+			wrongImages := make([]string, count*(options-1))
+			for i := range wrongImages {
+				wrongImages[i] = fmt.Sprintf("wrong%d.jpg", i)
+			}
 
-	if len(questions) != count {
-		t.Errorf("Expected %d questions, got %d", count, len(questions))
-	}
+			questions, answers := generateQuestions(newRand(1), correctImages, wrongImages, count, options, nil)
 
-	for i, question := range questions {
-		// Check that both images are set
-		if question.Img1 == "" || question.Img2 == "" {
-			t.Errorf("Question %d has empty image: Img1=%s, Img2=%s", i, question.Img1, question.Img2)
-		}
+			if len(questions) != count {
+				t.Errorf("Expected %d questions, got %d", count, len(questions))
+			}
+			if len(answers) != count {
+				t.Errorf("Expected %d answers, got %d", count, len(answers))
+			}
 
-		// Check that correct answer is either 1 or 2
-		if question.Correct != 1 && question.Correct != 2 {
-			t.Errorf("Question %d has invalid correct answer: %d", i, question.Correct)
-		}
+			used := make(map[string]bool)
+			for i, question := range questions {
+				if len(question.Options) != options || answers[i].Options != options {
+					t.Fatalf("Question %d has %d options, key says %d", i, len(question.Options), answers[i].Options)
+				}
 
-		// Check that images start with "/"
-		if !strings.HasPrefix(question.Img1, "/") {
-			t.Errorf("Question %d Img1 doesn't start with '/': %s", i, question.Img1)
-		}
-		if !strings.HasPrefix(question.Img2, "/") {
-			t.Errorf("Question %d Img2 doesn't start with '/': %s", i, question.Img2)
-		}
+				// Check that the correct option points at the only correct image
+				for j, img := range question.Options {
+					if isCorrect := strings.HasPrefix(img, "correct"); isCorrect != (j == answers[i].Correct) {
+						t.Errorf("Question %d option %d is %s, correct option is %d", i, j, img, answers[i].Correct)
+					}
+					if used[img] {
+						t.Errorf("Image %s is used twice", img)
+					}
+					used[img] = true
+				}
+			}
+		})
 	}
 }
 
@@ -163,8 +118,9 @@ func TestRandomIndex(t *testing.T) {
 	seen := make(map[int]bool)
 
 	// Run multiple times to ensure randomness
+	r := newRand(time.Now().UnixNano())
 	for i := 0; i < 100; i++ {
-		index := randomIndex(length)
+		index := randomIndex(r, length)
 		if index < 0 || index >= length {
 			t.Errorf("Random index %d is out of range [0, %d)", index, length)
 		}
@@ -181,7 +137,7 @@ func TestRandomIndex(t *testing.T) {
 func TestRandomIndexZero(t *testing.T) {
 	// randomIndex with length 0 should panic, so we test that it doesn't panic
 	// by using a small positive number instead
-	index := randomIndex(1)
+	index := randomIndex(newRand(1), 1)
 	if index != 0 {
 		t.Errorf("Expected 0 for length 1, got %d", index)
 	}
@@ -220,33 +176,30 @@ func TestGameDataHandler(t *testing.T) {
 		}
 	}
 
+	// Describe every wrong image; this must stay hidden until answered
+	if err := os.WriteFile(filepath.Join(choiceBDir, "index.json"), []byte(`{
+		"wrong1.jpg": {"reveal": "Secret"}, "wrong2.jpg": {"reveal": "Secret"},
+		"wrong3.jpg": {"reveal": "Secret"}, "wrong4.jpg": {"reveal": "Secret"},
+		"wrong5.jpg": {"reveal": "Secret"}
+	}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
 	// Create ending image
 	endingFile := filepath.Join(endingDir, "ending.jpg")
 	if err := os.WriteFile(endingFile, []byte("ending content"), 0644); err != nil {
 		t.Fatal(err)
 	}
 
-	// Create request with auth
-	req := httptest.NewRequest("GET", "/game-data?auth=test-auth", nil)
-	w := httptest.NewRecorder()
-
-	// Temporarily set environment variables for testing
-	originalChoiceA := os.Getenv("CHOICE_A_IMG_DIR")
-	originalChoiceB := os.Getenv("CHOICE_B_IMG_DIR")
-	originalEnding := os.Getenv("ENDING_IMG_DIR")
-	originalAuth := os.Getenv("API_AUTH")
-
-	os.Setenv("CHOICE_A_IMG_DIR", choiceADir)
-	os.Setenv("CHOICE_B_IMG_DIR", choiceBDir)
-	os.Setenv("ENDING_IMG_DIR", endingDir)
-	os.Setenv("API_AUTH", "test-auth")
+	// Index the temporary directories
+	c := newImageCatalog(choiceADir, choiceBDir, endingDir, "")
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	catalogs = map[string]*imageCatalog{config.DefaultDeck: c}
 
-	defer func() {
-		os.Setenv("CHOICE_A_IMG_DIR", originalChoiceA)
-		os.Setenv("CHOICE_B_IMG_DIR", originalChoiceB)
-		os.Setenv("ENDING_IMG_DIR", originalEnding)
-		os.Setenv("API_AUTH", originalAuth)
-	}()
+	req := httptest.NewRequest("GET", "/game-data", nil)
+	w := httptest.NewRecorder()
 
 	// Call the handler
 	gameDataHandler(w, req)
@@ -267,22 +220,147 @@ func TestGameDataHandler(t *testing.T) {
 		t.Error("Expected questions in response")
 	}
 
-	// Check that ending photo is set
-	if gameData.EndingPhoto == "" {
-		t.Error("Expected ending photo in response")
+	// Check the contract version and that a game was registered
+	if gameData.Version != gameDataVersion {
+		t.Errorf("Expected version %d, got %d", gameDataVersion, gameData.Version)
+	}
+	if gameData.GameID == "" {
+		t.Error("Expected game id in response")
+	}
+
+	// Check that image URLs are opaque
+	for i, question := range gameData.Questions {
+		if len(question.Options) != 2 {
+			t.Errorf("Question %d has %d options, expected 2", i, len(question.Options))
+		}
+		for _, img := range question.Options {
+			if !strings.HasPrefix(img, "/img/") || strings.Contains(img, "choice_") {
+				t.Errorf("Question %d has a non-opaque image URL: %s", i, img)
+			}
+		}
+	}
+
+	// Check that answers and image metadata are not leaked to the client
+	if strings.Contains(w.Body.String(), `"correct":`) || strings.Contains(w.Body.String(), "Secret") {
+		t.Errorf("Response leaks the correct option: %s", w.Body.String())
+	}
+
+	// Check that the ending photo waits for the result
+	if strings.Contains(w.Body.String(), "endingPhoto") {
+		t.Errorf("Response reveals the ending photo upfront: %s", w.Body.String())
+	}
+}
+
+// TestGameDataHandlerSeed tests that a seed replays the same questions
+func TestGameDataHandlerSeed(t *testing.T) {
+	choiceA, choiceB, ending := newTestCatalogDirs(t, 5, 5, 1)
+	c := newImageCatalog(choiceA, choiceB, ending, "")
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	catalogs = map[string]*imageCatalog{config.DefaultDeck: c}
+
+	// play requests a game and returns its seed and the files behind its images
+	play := func(url string) (int64, []string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		gameDataHandler(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var gameData GameData
+		if err := json.NewDecoder(w.Body).Decode(&gameData); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		var paths []string
+		for _, q := range gameData.Questions {
+			for _, img := range q.Options {
+				path, _ := games.imagePath(config.DefaultDeck, strings.TrimPrefix(img, "/img/"))
+				paths = append(paths, path)
+			}
+		}
+		return gameData.Seed, paths
+	}
+
+	seed, first := play("/game-data")
+	replayed, second := play(fmt.Sprintf("/game-data?seed=%d", seed))
+	if replayed != seed {
+		t.Errorf("Expected seed %d to be returned, got %d", seed, replayed)
+	}
+	if strings.Join(first, ",") != strings.Join(second, ",") {
+		t.Errorf("Expected the same images for seed %d, got %v and %v", seed, first, second)
+	}
+
+	for _, bad := range []string{"abc", "-1", "9007199254740992"} {
+		w := httptest.NewRecorder()
+		gameDataHandler(w, httptest.NewRequest(http.MethodGet, "/game-data?seed="+bad, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Seed %s: expected status 400, got %d", bad, w.Code)
+		}
+	}
+}
+
+// TestGameDataHandlerSpeed tests that speed games hold back their questions
+func TestGameDataHandlerSpeed(t *testing.T) {
+	choiceA, choiceB, ending := newTestCatalogDirs(t, 5, 5, 1)
+	c := newImageCatalog(choiceA, choiceB, ending, "")
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	catalogs = map[string]*imageCatalog{config.DefaultDeck: c}
+
+	deck := &config.Deck{Name: config.DefaultDeck, QuestionCount: 3, OptionCount: 2, QuestionTime: 10 * time.Second}
+	req := httptest.NewRequest(http.MethodGet, "/game-data?mode=speed", nil)
+	w := httptest.NewRecorder()
+	gameDataHandler(w, req.WithContext(context.WithValue(req.Context(), deckContextKey, deck)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var gameData GameData
+	if err := json.NewDecoder(w.Body).Decode(&gameData); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if gameData.Mode != modeSpeed || gameData.QuestionCount != 3 || gameData.TimeLimitMs != 10000 || len(gameData.Questions) != 0 {
+		t.Errorf("Unexpected speed game: %+v", gameData)
+	}
+
+	tests := []struct {
+		name         string
+		url          string
+		questionTime time.Duration
+	}{
+		{"unknown mode", "/game-data?mode=slow", 10 * time.Second},
+		{"speed games disabled", "/game-data?mode=speed", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deck := &config.Deck{Name: config.DefaultDeck, QuestionCount: 3, OptionCount: 2, QuestionTime: tt.questionTime}
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			w := httptest.NewRecorder()
+			gameDataHandler(w, req.WithContext(context.WithValue(req.Context(), deckContextKey, deck)))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
 	}
 }
 
 // TestGameDataHandlerWithRealImages tests gameDataHandler with real image directories
 func TestGameDataHandlerWithRealImages(t *testing.T) {
+	if _, err := os.Stat(config.Env().ImagesDir); os.IsNotExist(err) {
+		t.Skipf("Image directory %s does not exist", config.Env().ImagesDir)
+	}
+
+	c := newImageCatalog(config.Env().ChoiceAImgDir, config.Env().ChoiceBImgDir, config.Env().EndingImgDir, config.Env().Manifest)
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	catalogs = map[string]*imageCatalog{config.DefaultDeck: c}
+
 	req := httptest.NewRequest("GET", "/game-data", nil)
 	w := httptest.NewRecorder()
 
-	// Temporarily set environment variables for testing
-	originalAuth := os.Getenv("API_AUTH")
-	os.Setenv("API_AUTH", "test-auth")
-	defer os.Setenv("API_AUTH", originalAuth)
-
 	gameDataHandler(w, req)
 
 	// The handler should return 200 if image directories exist
@@ -302,11 +380,6 @@ func TestGameDataHandlerWithRealImages(t *testing.T) {
 	if len(gameData.Questions) == 0 {
 		t.Error("Expected questions in response")
 	}
-
-	// Check that ending photo is set
-	if gameData.EndingPhoto == "" {
-		t.Error("Expected ending photo in response")
-	}
 }
 
 // TestRespondWithError tests the respondWithError function
@@ -355,13 +428,15 @@ func BenchmarkGenerateQuestions(b *testing.B) {
 	}
 
 	b.ResetTimer()
+	r := newRand(1)
 	for i := 0; i < b.N; i++ {
-		generateQuestions(correctImages, wrongImages, 10)
+		generateQuestions(r, correctImages, wrongImages, 10, 4, nil)
 	}
 }
 
 func BenchmarkRandomIndex(b *testing.B) {
+	r := newRand(1)
 	for i := 0; i < b.N; i++ {
-		randomIndex(100)
+		randomIndex(r, 100)
 	}
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"whos-your-mate/config"
+)
+
+const (
+	// manifestCurated games only ask questions from the manifest
+	manifestCurated = "curated"
+	// manifestMixed games top up the manifest questions with random ones
+	manifestMixed = "mixed"
+)
+
+// difficultyRank orders questions from easy to hard; random questions rank
+// as medium
+var difficultyRank = map[string]int{"easy": 1, "medium": 2, "hard": 3}
+
+// questionManifest is a hand-curated list of questions for a deck
+type questionManifest struct {
+	Mode      string             `json:"mode"`
+	Questions []manifestQuestion `json:"questions"`
+}
+
+// manifestQuestion is one curated question. Correct is a path relative to
+// the deck's choice_a directory and Wrong are paths relative to its
+// choice_b directory.
+type manifestQuestion struct {
+	Correct    string   `json:"correct"`
+	Wrong      []string `json:"wrong"`
+	Caption    string   `json:"caption"`
+	Difficulty string   `json:"difficulty"`
+	Order      int      `json:"order"` // questions with an order come first, lowest first
+
+	correctPath string
+	wrongPaths  []string
+}
+
+// loadManifest reads and validates the manifest at path. Every problem is
+// reported, including referenced images that do not exist.
+func loadManifest(path, choiceADir, choiceBDir string) (*questionManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m questionManifest
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var errs []error
+	switch m.Mode {
+	case "":
+		m.Mode = manifestCurated
+	case manifestCurated, manifestMixed:
+	default:
+		errs = append(errs, fmt.Errorf("mode must be %q or %q, got %q", manifestCurated, manifestMixed, m.Mode))
+	}
+	if len(m.Questions) == 0 {
+		errs = append(errs, errors.New("no questions"))
+	}
+
+	for i := range m.Questions {
+		q := &m.Questions[i]
+		fail := func(format string, args ...any) {
+			errs = append(errs, fmt.Errorf("question %d: %s", i+1, fmt.Sprintf(format, args...)))
+		}
+
+		if q.correctPath, err = manifestImage(choiceADir, q.Correct); err != nil {
+			fail("correct %v", err)
+		}
+		if n := len(q.Wrong) + 1; n < 2 || n > config.MaxOptionCount {
+			fail("needs between 1 and %d wrong images, got %d", config.MaxOptionCount-1, len(q.Wrong))
+		}
+		q.wrongPaths = make([]string, len(q.Wrong))
+		for j, wrong := range q.Wrong {
+			if q.wrongPaths[j], err = manifestImage(choiceBDir, wrong); err != nil {
+				fail("wrong %v", err)
+			} else if slices.Contains(q.wrongPaths[:j], q.wrongPaths[j]) {
+				fail("wrong image %q is listed twice", wrong)
+			}
+		}
+		if _, ok := difficultyRank[q.Difficulty]; q.Difficulty != "" && !ok {
+			fail("difficulty must be easy, medium or hard, got %q", q.Difficulty)
+		}
+		if q.Order < 0 {
+			fail("order must not be negative, got %d", q.Order)
+		}
+	}
+
+	for i, err := range errs {
+		errs[i] = fmt.Errorf("%s: %w", path, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &m, nil
+}
+
+// manifestImage resolves an image of the manifest inside dir and checks
+// that it is an existing image file
+func manifestImage(dir, name string) (string, error) {
+	if name == "" {
+		return "", errors.New("image is missing")
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("image %q must be a path inside %s", name, dir)
+	}
+	if !supportExtensions[strings.ToLower(filepath.Ext(name))] {
+		return "", fmt.Errorf("image %q is not a supported image type", name)
+	}
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("image %q: %w", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("image %q is not a file", name)
+	}
+	return path, nil
+}
+
+// rankedQuestion is a question of a game along with what it is sorted by
+type rankedQuestion struct {
+	question Question
+	key      answerKey
+	order    int
+	rank     int
+}
+
+// buildQuestions creates the questions of a game. Without a manifest all
+// questions are paired at random. Otherwise up to count curated questions
+// are picked, topped up with random ones in mixed mode from images the
+// curated questions do not use. Questions are drawn from r.
+func buildQuestions(r *rand.Rand, images imageSet, count, options int) ([]Question, []answerKey, error) {
+	m := images.Manifest
+	if m == nil {
+		if err := enoughImages(images.ChoiceA, images.ChoiceB, count, options); err != nil {
+			return nil, nil, err
+		}
+		questions, answers := generateQuestions(r, images.ChoiceA, images.ChoiceB, count, options, images.Weight)
+		return questions, answers, nil
+	}
+
+	picked := slices.Clone(m.Questions)
+	r.Shuffle(len(picked), func(i, j int) { picked[i], picked[j] = picked[j], picked[i] })
+	picked = picked[:min(count, len(picked))]
+
+	ranked := make([]rankedQuestion, 0, count)
+	used := make(map[string]bool)
+	for _, q := range picked {
+		correct := r.Intn(len(q.wrongPaths) + 1)
+		opts := slices.Insert(slices.Clone(q.wrongPaths), correct, q.correctPath)
+		for _, img := range opts {
+			used[img] = true
+		}
+		ranked = append(ranked, rankedQuestion{
+			question: Question{Options: opts, Caption: q.Caption, Difficulty: q.Difficulty},
+			key:      answerKey{Correct: correct, Options: len(opts)},
+			order:    q.Order,
+			rank:     difficultyRank[cmp.Or(q.Difficulty, "medium")],
+		})
+	}
+
+	if m.Mode == manifestMixed && len(ranked) < count {
+		isUsed := func(img string) bool { return used[img] }
+		choiceA := slices.DeleteFunc(slices.Clone(images.ChoiceA), isUsed)
+		choiceB := slices.DeleteFunc(slices.Clone(images.ChoiceB), isUsed)
+		remaining := count - len(ranked)
+		if err := enoughImages(choiceA, choiceB, remaining, options); err != nil {
+			return nil, nil, err
+		}
+		questions, answers := generateQuestions(r, choiceA, choiceB, remaining, options, images.Weight)
+		for i := range questions {
+			ranked = append(ranked, rankedQuestion{question: questions[i], key: answers[i], rank: difficultyRank["medium"]})
+		}
+	}
+
+	// Ordered questions come first, the rest ramps up from easy to hard with
+	// questions of equal difficulty in random order
+	r.Shuffle(len(ranked), func(i, j int) { ranked[i], ranked[j] = ranked[j], ranked[i] })
+	sort.SliceStable(ranked, func(i, j int) bool {
+		x, y := ranked[i], ranked[j]
+		if (x.order > 0) != (y.order > 0) {
+			return x.order > 0
+		}
+		if x.order != y.order {
+			return x.order < y.order
+		}
+		return x.rank < y.rank
+	})
+
+	questions := make([]Question, len(ranked))
+	answers := make([]answerKey, len(ranked))
+	for i, q := range ranked {
+		questions[i], answers[i] = q.question, q.key
+	}
+	return questions, answers, nil
+}
+
+// enoughImages checks that there are enough images for count random
+// questions with the given number of options
+func enoughImages(choiceA, choiceB []string, count, options int) error {
+	if len(choiceA) < count || len(choiceB) < count*(options-1) {
+		return fmt.Errorf("Not enough images. Correct Images: %d, Wrong Images: %d, need %d and %d", len(choiceA), len(choiceB), count, count*(options-1))
+	}
+	return nil
+}
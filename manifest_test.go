@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeManifest writes a manifest file next to the image directories
+func writeManifest(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "questions.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadManifest tests loading a valid manifest
+func TestLoadManifest(t *testing.T) {
+	choiceA, choiceB, _ := newTestCatalogDirs(t, 2, 3, 1)
+	path := writeManifest(t, t.TempDir(), `{
+  "questions": [
+    {"correct": "imga.jpg", "wrong": ["imga.jpg", "imgb.jpg"], "caption": "Baby photos", "difficulty": "easy", "order": 1},
+    {"correct": "imgb.jpg", "wrong": ["imgc.jpg"]}
+  ]
+}`)
+
+	m, err := loadManifest(path, choiceA, choiceB)
+	if err != nil {
+		t.Fatalf("loadManifest failed: %v", err)
+	}
+	if m.Mode != manifestCurated || len(m.Questions) != 2 {
+		t.Fatalf("Unexpected manifest: %+v", m)
+	}
+	q := m.Questions[0]
+	if q.correctPath != filepath.Join(choiceA, "imga.jpg") || q.wrongPaths[1] != filepath.Join(choiceB, "imgb.jpg") {
+		t.Errorf("Unexpected resolved paths: %s, %v", q.correctPath, q.wrongPaths)
+	}
+}
+
+// TestLoadManifestErrors tests that every problem of a manifest is reported
+func TestLoadManifestErrors(t *testing.T) {
+	choiceA, choiceB, _ := newTestCatalogDirs(t, 1, 1, 1)
+	path := writeManifest(t, t.TempDir(), `{
+  "mode": "sometimes",
+  "questions": [
+    {"correct": "missing.jpg", "wrong": ["imga.jpg"]},
+    {"correct": "../choice_b/imga.jpg", "wrong": ["notes.txt"]},
+    {"correct": "imga.jpg", "wrong": []},
+    {"correct": "imga.jpg", "wrong": ["imga.jpg", "imga.jpg"], "difficulty": "insane", "order": -1}
+  ]
+}`)
+
+	_, err := loadManifest(path, choiceA, choiceB)
+	if err == nil {
+		t.Fatal("Expected manifest errors")
+	}
+	for _, want := range []string{
+		path + `: mode must be "curated" or "mixed", got "sometimes"`,
+		path + `: question 1: correct image "missing.jpg"`,
+		path + `: question 2: correct image "../choice_b/imga.jpg" must be a path inside`,
+		path + `: question 2: wrong image "notes.txt" is not a supported image type`,
+		path + `: question 3: needs between 1 and 5 wrong images, got 0`,
+		path + `: question 4: wrong image "imga.jpg" is listed twice`,
+		path + `: question 4: difficulty must be easy, medium or hard, got "insane"`,
+		path + `: question 4: order must not be negative, got -1`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error %q, got:\n%v", want, err)
+		}
+	}
+
+	// Unknown fields are most likely typos
+	path = writeManifest(t, t.TempDir(), `{"questions": [{"corect": "imga.jpg"}]}`)
+	if _, err := loadManifest(path, choiceA, choiceB); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("Expected unknown field error, got %v", err)
+	}
+}
+
+// TestBuildQuestionsCurated tests that curated questions keep their
+// images, order and difficulty
+func TestBuildQuestionsCurated(t *testing.T) {
+	choiceA, choiceB, _ := newTestCatalogDirs(t, 4, 4, 1)
+	path := writeManifest(t, t.TempDir(), `{
+  "questions": [
+    {"correct": "imga.jpg", "wrong": ["imga.jpg"], "difficulty": "hard"},
+    {"correct": "imgb.jpg", "wrong": ["imgb.jpg", "imgc.jpg"], "caption": "Second", "order": 2},
+    {"correct": "imgc.jpg", "wrong": ["imgd.jpg"], "difficulty": "easy"},
+    {"correct": "imgd.jpg", "wrong": ["imgc.jpg"], "caption": "First", "order": 1}
+  ]
+}`)
+	m, err := loadManifest(path, choiceA, choiceB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	questions, answers, err := buildQuestions(newRand(1), imageSet{Manifest: m}, 5, 2)
+	if err != nil {
+		t.Fatalf("buildQuestions failed: %v", err)
+	}
+	if len(questions) != 4 {
+		t.Fatalf("Expected the 4 curated questions, got %d", len(questions))
+	}
+
+	wantCorrect := []string{"imgd.jpg", "imgb.jpg", "imgc.jpg", "imga.jpg"}
+	for i, q := range questions {
+		if len(q.Options) != answers[i].Options {
+			t.Errorf("Question %d has %d options, key says %d", i, len(q.Options), answers[i].Options)
+		}
+		if got := q.Options[answers[i].Correct]; got != filepath.Join(choiceA, wantCorrect[i]) {
+			t.Errorf("Question %d: expected correct image %s, got %s", i, wantCorrect[i], got)
+		}
+	}
+	if questions[0].Caption != "First" || questions[1].Caption != "Second" || len(questions[1].Options) != 3 {
+		t.Errorf("Unexpected ordered questions: %+v", questions[:2])
+	}
+	if questions[2].Difficulty != "easy" || questions[3].Difficulty != "hard" {
+		t.Errorf("Expected easy before hard, got %+v", questions[2:])
+	}
+}
+
+// TestBuildQuestionsMixed tests topping up curated questions with random
+// ones that do not reuse their images
+func TestBuildQuestionsMixed(t *testing.T) {
+	choiceA, choiceB, _ := newTestCatalogDirs(t, 4, 4, 1)
+	path := writeManifest(t, t.TempDir(), `{
+  "mode": "mixed",
+  "questions": [{"correct": "imga.jpg", "wrong": ["imga.jpg"], "caption": "Curated"}]
+}`)
+	m, err := loadManifest(path, choiceA, choiceB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := imageSet{Manifest: m}
+	for _, name := range []string{"imga.jpg", "imgb.jpg", "imgc.jpg", "imgd.jpg"} {
+		images.ChoiceA = append(images.ChoiceA, filepath.Join(choiceA, name))
+		images.ChoiceB = append(images.ChoiceB, filepath.Join(choiceB, name))
+	}
+
+	questions, _, err := buildQuestions(newRand(1), images, 4, 2)
+	if err != nil {
+		t.Fatalf("buildQuestions failed: %v", err)
+	}
+	if len(questions) != 4 {
+		t.Fatalf("Expected 4 questions, got %d", len(questions))
+	}
+	used := make(map[string]bool)
+	curated := 0
+	for _, q := range questions {
+		if q.Caption == "Curated" {
+			curated++
+		}
+		for _, img := range q.Options {
+			if used[img] {
+				t.Errorf("Image %s is used twice", img)
+			}
+			used[img] = true
+		}
+	}
+	if curated != 1 {
+		t.Errorf("Expected the curated question once, got %d", curated)
+	}
+
+	// Too few images left for the random questions
+	if _, _, err := buildQuestions(newRand(1), images, 6, 2); err == nil {
+		t.Error("Expected not enough images error")
+	}
+}
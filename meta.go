@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// metaIndexFile describes the images of its directory by file name
+const metaIndexFile = "index.json"
+
+// ImageMeta describes an image. It is only sent to the client once the
+// question showing the image has been answered.
+type ImageMeta struct {
+	Caption string `json:"caption,omitempty"` // e.g. "Summer 2019, Lisbon"
+	Reveal  string `json:"reveal,omitempty"`  // e.g. the name of the person shown
+	Credit  string `json:"credit,omitempty"`  // photographer or source
+	Tier    string `json:"tier,omitempty"`    // score tier of an ending image
+}
+
+// IsZero reports whether the image has no metadata
+func (m ImageMeta) IsZero() bool {
+	return m == ImageMeta{}
+}
+
+// merge returns m with the fields set in override replaced
+func (m ImageMeta) merge(override ImageMeta) ImageMeta {
+	if override.Caption != "" {
+		m.Caption = override.Caption
+	}
+	if override.Reveal != "" {
+		m.Reveal = override.Reveal
+	}
+	if override.Credit != "" {
+		m.Credit = override.Credit
+	}
+	if override.Tier != "" {
+		m.Tier = override.Tier
+	}
+	return m
+}
+
+// loadImageMeta reads the metadata of images found by loadImages. An image
+// is described by a "<image>.json" sidecar file, by its entry in the
+// index.json of its directory, or both, in which case the sidecar wins
+// field by field. Images without metadata are left out of the result.
+func loadImageMeta(images []string) (map[string]ImageMeta, error) {
+	var errs []error
+	indexes := make(map[string]map[string]ImageMeta)
+	meta := make(map[string]ImageMeta)
+
+	for _, img := range images {
+		dir := filepath.Dir(img)
+		index, ok := indexes[dir]
+		if !ok {
+			index = make(map[string]ImageMeta)
+			if err := readMetaFile(filepath.Join(dir, metaIndexFile), &index); err != nil {
+				errs = append(errs, err)
+			}
+			indexes[dir] = index
+		}
+
+		var sidecar ImageMeta
+		if err := readMetaFile(img+".json", &sidecar); err != nil {
+			errs = append(errs, err)
+		}
+		if m := index[filepath.Base(img)].merge(sidecar); !m.IsZero() {
+			meta[img] = m
+		}
+	}
+	return meta, errors.Join(errs...)
+}
+
+// readMetaFile decodes the JSON file at path into v; a missing file is not
+// an error
+func readMetaFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadImageMeta tests sidecar files, directory indexes and how they merge
+func TestLoadImageMeta(t *testing.T) {
+	dir := t.TempDir()
+	lisbon := filepath.Join(dir, "lisbon.jpg")
+	paris := filepath.Join(dir, "paris.jpg")
+	plain := filepath.Join(dir, "plain.jpg")
+	nested := filepath.Join(dir, "2020", "rome.jpg")
+	for _, img := range []string{lisbon, paris, plain, nested} {
+		writeImage(t, img, img)
+	}
+	writeImage(t, filepath.Join(dir, metaIndexFile), `{
+  "lisbon.jpg": {"caption": "Lisbon", "credit": "Alice"},
+  "paris.jpg": {"caption": "Paris"}
+}`)
+	writeImage(t, lisbon+".json", `{"caption": "Summer 2019, Lisbon", "reveal": "Bob"}`)
+	writeImage(t, nested+".json", `{"reveal": "Carol"}`)
+
+	meta, err := loadImageMeta([]string{lisbon, paris, plain, nested})
+	if err != nil {
+		t.Fatalf("loadImageMeta failed: %v", err)
+	}
+
+	tests := []struct {
+		img  string
+		want ImageMeta
+	}{
+		{lisbon, ImageMeta{Caption: "Summer 2019, Lisbon", Reveal: "Bob", Credit: "Alice"}},
+		{paris, ImageMeta{Caption: "Paris"}},
+		{nested, ImageMeta{Reveal: "Carol"}},
+	}
+	for _, tt := range tests {
+		if got := meta[tt.img]; got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", filepath.Base(tt.img), tt.want, got)
+		}
+	}
+	if _, ok := meta[plain]; ok {
+		t.Error("Expected no metadata for an image without sidecar or index entry")
+	}
+}
+
+// TestLoadImageMetaInvalid tests that broken metadata files are reported
+func TestLoadImageMetaInvalid(t *testing.T) {
+	dir := t.TempDir()
+	img := filepath.Join(dir, "a.jpg")
+	writeImage(t, img, "a")
+	writeImage(t, img+".json", `{"caption": `)
+
+	if _, err := loadImageMeta([]string{img}); err == nil || !strings.Contains(err.Error(), img+".json") {
+		t.Errorf("Expected error naming the sidecar, got %v", err)
+	}
+}
+
+// TestImageSetReveal tests looking up the metadata of question options
+func TestImageSetReveal(t *testing.T) {
+	s := imageSet{Meta: map[string]ImageMeta{"b.jpg": {Reveal: "Bob"}}}
+	if got := s.reveal([]string{"a.jpg", "c.jpg"}); got != nil {
+		t.Errorf("Expected nil without metadata, got %+v", got)
+	}
+	got := s.reveal([]string{"a.jpg", "b.jpg"})
+	if len(got) != 2 || !got[0].IsZero() || got[1].Reveal != "Bob" {
+		t.Errorf("Unexpected reveal: %+v", got)
+	}
+}
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+const (
+	// partyCodeLength is the number of characters of a room code
+	partyCodeLength = 5
+	// partyCodeAlphabet leaves out characters that are easily mixed up
+	partyCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// maxPartyPlayers caps the players of a room
+	maxPartyPlayers = 50
+	// defaultPartyQuestionTime is the time per question in decks that have
+	// speed games disabled
+	defaultPartyQuestionTime = 20 * time.Second
+)
+
+// Phases of a party room
+const (
+	phaseLobby    = "lobby"    // players are joining
+	phaseQuestion = "question" // a question is open for answers
+	phaseScores   = "scores"   // the scoreboard of the last question is shown
+	phaseFinished = "finished" // the last question has been scored
+)
+
+var (
+	errRoomNotFound    = errors.New("room not found")
+	errNotHost         = errors.New("only the host can do this")
+	errNotInRoom       = errors.New("player is not in this room")
+	errRoomStarted     = errors.New("game already started")
+	errRoomFull        = errors.New("room is full")
+	errWrongPhase      = errors.New("not possible at this point of the game")
+	errQuestionClosed  = errors.New("question is closed")
+	errAlreadyAnswered = errors.New("question already answered")
+)
+
+// PartyRequest is the body of the POST endpoints of party mode; each uses
+// the fields it needs
+type PartyRequest struct {
+	Code      string `json:"code"`
+	Player    string `json:"player"`    // name of a joining player
+	PlayerID  string `json:"playerId"`  // returned on joining
+	HostToken string `json:"hostToken"` // returned on creating the room
+	Question  int    `json:"question"`
+	Option    *int   `json:"option"`
+}
+
+// PartyJoined is returned when a player creates or joins a room. The host
+// of a room plays too and is the only one holding its host token.
+type PartyJoined struct {
+	Code      string `json:"code"`
+	PlayerID  string `json:"playerId"`
+	HostToken string `json:"hostToken,omitempty"`
+}
+
+// PartyLobby is sent whenever the players of a room change
+type PartyLobby struct {
+	Code    string   `json:"code"`
+	Phase   string   `json:"phase"`
+	Players []string `json:"players"`
+}
+
+// PartyQuestion is sent to everyone in a room when a question opens
+type PartyQuestion struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+	Question
+	TimeLimitMs int64 `json:"timeLimitMs"`
+	RemainingMs int64 `json:"remainingMs"`
+}
+
+// PartyScore is a player's line on the scoreboard
+type PartyScore struct {
+	Player  string `json:"player"`
+	Points  int    `json:"points"`
+	Correct int    `json:"correct"`
+	Gained  int    `json:"gained"` // with the last question
+}
+
+// PartyScoreboard is sent to everyone in a room once a question closes
+type PartyScoreboard struct {
+	Index    int          `json:"index"`
+	Count    int          `json:"count"`
+	Answer   int          `json:"answer"` // index of the correct option
+	Reveal   []ImageMeta  `json:"reveal,omitempty"`
+	Finished bool         `json:"finished"`
+	Scores   []PartyScore `json:"scores"` // best first
+}
+
+// PartyAnswers is sent whenever a player answers the open question
+type PartyAnswers struct {
+	Index    int `json:"index"`
+	Answered int `json:"answered"`
+	Players  int `json:"players"`
+}
+
+// PartyWinner is sent once the last question of a room is scored
+type PartyWinner struct {
+	Players []string `json:"players"` // more than one on a tie
+	Points  int      `json:"points"`
+}
+
+// partyPlayer is a player in a room
+type partyPlayer struct {
+	id       string
+	name     string
+	points   int
+	correct  int
+	gained   int
+	answered bool
+}
+
+// partyRoom is a game played by everyone in it at the same time. The host
+// moves it from question to question; each question closes once everyone
+// has answered or its time is up.
+type partyRoom struct {
+	code      string
+	deck      string
+	hostToken string
+	questions []Question // with published images
+	answers   []answerKey
+	timeLimit time.Duration
+	phase     string
+	current   int // index of the open or last question
+	askedAt   time.Time
+	timer     *time.Timer // closes the open question at its deadline
+	players   []*partyPlayer
+	scores    *PartyScoreboard // of the last closed question
+	createdAt time.Time
+}
+
+// player returns the player of the room with the given ID
+func (room *partyRoom) player(id string) (*partyPlayer, bool) {
+	for _, p := range room.players {
+		if p.id == id {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// event returns an event of the room
+func (room *partyRoom) event(name string, data any) busEvent {
+	return busEvent{topic: busTopic{deck: room.deck, room: room.code}, name: name, data: data}
+}
+
+// lobby returns the lobby event of the room
+func (room *partyRoom) lobby() busEvent {
+	names := make([]string, len(room.players))
+	for i, p := range room.players {
+		names[i] = p.name
+	}
+	return room.event("lobby", PartyLobby{Code: room.code, Phase: room.phase, Players: names})
+}
+
+// question returns the event of the open question
+func (room *partyRoom) question() busEvent {
+	remaining := room.timeLimit - time.Since(room.askedAt)
+	return room.event("question", PartyQuestion{
+		Index:       room.current,
+		Count:       len(room.questions),
+		Question:    room.questions[room.current],
+		TimeLimitMs: room.timeLimit.Milliseconds(),
+		RemainingMs: max(remaining.Milliseconds(), 0),
+	})
+}
+
+// answerCount returns the event counting the answers to the open question
+func (room *partyRoom) answerCount() busEvent {
+	answered := 0
+	for _, p := range room.players {
+		if p.answered {
+			answered++
+		}
+	}
+	return room.event("answers", PartyAnswers{Index: room.current, Answered: answered, Players: len(room.players)})
+}
+
+// state returns the events that bring a new subscriber up to date
+func (room *partyRoom) state() []busEvent {
+	events := []busEvent{room.lobby()}
+	switch room.phase {
+	case phaseQuestion:
+		events = append(events, room.question(), room.answerCount())
+	case phaseScores, phaseFinished:
+		events = append(events, room.event("scores", *room.scores))
+	}
+	return events
+}
+
+// partyHub keeps the running party rooms and publishes their events
+type partyHub struct {
+	mu    sync.Mutex
+	rooms map[string]*partyRoom
+	ttl   time.Duration
+	bus   *eventBus
+}
+
+var parties = newPartyHub(gameTTL, bus)
+
+func newPartyHub(ttl time.Duration, bus *eventBus) *partyHub {
+	return &partyHub{rooms: make(map[string]*partyRoom), ttl: ttl, bus: bus}
+}
+
+// create opens a room of the given deck for the given questions, with host
+// as its first player
+func (h *partyHub) create(deck, host string, questions []Question, answers []answerKey, limit time.Duration) (PartyJoined, error) {
+	hostToken, err := newID()
+	if err != nil {
+		return PartyJoined{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return PartyJoined{}, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pruneLocked()
+	code, err := h.newCodeLocked()
+	if err != nil {
+		return PartyJoined{}, err
+	}
+	h.rooms[code] = &partyRoom{
+		code:      code,
+		deck:      deck,
+		hostToken: hostToken,
+		questions: questions,
+		answers:   answers,
+		timeLimit: limit,
+		phase:     phaseLobby,
+		players:   []*partyPlayer{{id: id, name: host}},
+		createdAt: time.Now(),
+	}
+	return PartyJoined{Code: code, PlayerID: id, HostToken: hostToken}, nil
+}
+
+// join adds a player to a room that has not started yet
+func (h *partyHub) join(deck, code, name string) (PartyJoined, error) {
+	id, err := newID()
+	if err != nil {
+		return PartyJoined{}, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, err := h.roomLocked(deck, code)
+	if err != nil {
+		return PartyJoined{}, err
+	}
+	if room.phase != phaseLobby {
+		return PartyJoined{}, errRoomStarted
+	}
+	if len(room.players) >= maxPartyPlayers {
+		return PartyJoined{}, errRoomFull
+	}
+	room.players = append(room.players, &partyPlayer{id: id, name: name})
+	h.bus.publish(room.lobby())
+	return PartyJoined{Code: room.code, PlayerID: id}, nil
+}
+
+// start asks the first question of a room
+func (h *partyHub) start(deck, code, hostToken string) error {
+	return h.advance(deck, code, hostToken, phaseLobby)
+}
+
+// next asks the question after the scoreboard that is shown
+func (h *partyHub) next(deck, code, hostToken string) error {
+	return h.advance(deck, code, hostToken, phaseScores)
+}
+
+// advance asks the next question of a room in the given phase on behalf
+// of its host
+func (h *partyHub) advance(deck, code, hostToken, phase string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, err := h.roomLocked(deck, code)
+	if err != nil {
+		return err
+	}
+	if !passwordMatches(hostToken, room.hostToken) {
+		return errNotHost
+	}
+	if room.phase != phase {
+		return errWrongPhase
+	}
+	if phase == phaseScores {
+		room.current++
+	}
+	h.askLocked(room)
+	return nil
+}
+
+// askLocked opens the current question of a room; h.mu must be held
+func (h *partyHub) askLocked(room *partyRoom) {
+	room.phase = phaseQuestion
+	room.askedAt = time.Now()
+	for _, p := range room.players {
+		p.answered, p.gained = false, 0
+	}
+	index := room.current
+	room.timer = time.AfterFunc(room.timeLimit+answerGrace, func() { h.expire(room, index) })
+	h.bus.publish(room.lobby())
+	h.bus.publish(room.question())
+}
+
+// expire closes a question whose time is up unless it closed already
+func (h *partyHub) expire(room *partyRoom, index int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if room.phase == phaseQuestion && room.current == index {
+		h.closeLocked(room)
+	}
+}
+
+// answer records a player's option for the open question of a room,
+// closing it once everyone has answered. It returns the answer for the
+// image statistics.
+func (h *partyHub) answer(deck, code, playerID string, question, option int) (answerStat, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, err := h.roomLocked(deck, code)
+	if err != nil {
+		return answerStat{}, err
+	}
+	p, ok := room.player(playerID)
+	if !ok {
+		return answerStat{}, errNotInRoom
+	}
+	if room.phase != phaseQuestion || question < room.current {
+		return answerStat{}, errQuestionClosed
+	}
+	if question != room.current {
+		return answerStat{}, errQuestionOrder
+	}
+	if p.answered {
+		return answerStat{}, errAlreadyAnswered
+	}
+	key := room.answers[question]
+	if option < 0 || option >= key.Options {
+		return answerStat{}, errInvalidOption
+	}
+
+	now := time.Now()
+	responseTime := now.Sub(room.askedAt)
+	p.answered = true
+	if option == key.Correct {
+		p.gained = timedPoints(room.timeLimit, responseTime)
+		p.points += p.gained
+		p.correct++
+	}
+	h.bus.publish(room.answerCount())
+	if !slices.ContainsFunc(room.players, func(p *partyPlayer) bool { return !p.answered }) {
+		h.closeLocked(room)
+	}
+	return answerStat{
+		Deck:       room.deck,
+		Images:     key.Images,
+		Correct:    key.Correct,
+		Picked:     option,
+		AnsweredAt: now,
+		ResponseMs: max(responseTime.Milliseconds(), 1),
+	}, nil
+}
+
+// closeLocked closes the open question of a room and sends everyone the
+// scoreboard; h.mu must be held
+func (h *partyHub) closeLocked(room *partyRoom) {
+	room.timer.Stop()
+	room.phase = phaseScores
+	if room.current == len(room.questions)-1 {
+		room.phase = phaseFinished
+	}
+
+	ranked := slices.Clone(room.players)
+	slices.SortStableFunc(ranked, func(a, b *partyPlayer) int { return b.points - a.points })
+	scores := make([]PartyScore, len(ranked))
+	for i, p := range ranked {
+		scores[i] = PartyScore{Player: p.name, Points: p.points, Correct: p.correct, Gained: p.gained}
+	}
+	key := room.answers[room.current]
+	room.scores = &PartyScoreboard{
+		Index:    room.current,
+		Count:    len(room.questions),
+		Answer:   key.Correct,
+		Reveal:   key.Reveal,
+		Finished: room.phase == phaseFinished,
+		Scores:   scores,
+	}
+	h.bus.publish(room.lobby())
+	h.bus.publish(room.event("scores", *room.scores))
+	if room.phase == phaseFinished {
+		winner := PartyWinner{Points: scores[0].Points}
+		for _, score := range scores {
+			if score.Points == winner.Points {
+				winner.Players = append(winner.Players, score.Player)
+			}
+		}
+		h.bus.publish(room.event("winner", winner))
+	}
+}
+
+// subscribe returns a stream of the events of a room for one of its
+// players, starting with the state the room is in
+func (h *partyHub) subscribe(deck, code, playerID string) (chan busEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, err := h.roomLocked(deck, code)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := room.player(playerID); !ok {
+		return nil, errNotInRoom
+	}
+	return h.bus.subscribe(busTopic{deck: room.deck, room: room.code}, room.state()...), nil
+}
+
+// present returns a stream of the events of a room for a big screen,
+// starting with the state the room is in
+func (h *partyHub) present(deck, code string) (chan busEvent, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, err := h.roomLocked(deck, code)
+	if err != nil {
+		return nil, err
+	}
+	return h.bus.subscribe(busTopic{deck: room.deck, room: room.code}, room.state()...), nil
+}
+
+// roomLocked returns the room of the given deck and code; h.mu must be held
+func (h *partyHub) roomLocked(deck, code string) (*partyRoom, error) {
+	room, ok := h.rooms[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok || room.deck != deck || time.Since(room.createdAt) > h.ttl {
+		return nil, errRoomNotFound
+	}
+	return room, nil
+}
+
+// newCodeLocked returns a code no running room uses; h.mu must be held
+func (h *partyHub) newCodeLocked() (string, error) {
+	b := make([]byte, partyCodeLength)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		for i := range b {
+			b[i] = partyCodeAlphabet[int(b[i])%len(partyCodeAlphabet)]
+		}
+		if _, ok := h.rooms[string(b)]; !ok {
+			return string(b), nil
+		}
+	}
+}
+
+// pruneLocked closes rooms older than the hub's TTL; h.mu must be held
+func (h *partyHub) pruneLocked() {
+	for code, room := range h.rooms {
+		if time.Since(room.createdAt) > h.ttl {
+			if room.timer != nil {
+				room.timer.Stop()
+			}
+			h.bus.publish(room.event(eventClosed, PartyLobby{Code: room.code, Phase: room.phase}))
+			delete(h.rooms, code)
+		}
+	}
+}
+
+// partyCreateHandler opens a room with new questions of the requested
+// deck and the requesting player as its host
+func partyCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req PartyRequest
+	if !readPartyRequest(w, r, &req) {
+		return
+	}
+	deck := deckOf(r)
+	c, ok := catalogs[deck.Name]
+	if !ok {
+		respondWithError(w, "Deck has no images", fmt.Errorf("no catalog for deck %s", deck.Name))
+		return
+	}
+	images := c.Snapshot()
+
+	seed := newSeed()
+	questions, answers, err := buildQuestions(newRand(seed), images, deck.QuestionCount, deck.OptionCount)
+	if err != nil {
+		respondWithError(w, "Not enough images to create questions", err)
+		return
+	}
+	images.describe(questions, answers)
+	// The images of the room are served through a game of their own
+	game, err := games.create(deck.Name, "", seed, false, answers, nil)
+	if err != nil {
+		respondWithError(w, "Could not create game", err)
+		return
+	}
+	if err := games.publishQuestions(game, questions); err != nil {
+		respondWithError(w, "Could not publish images", err)
+		return
+	}
+
+	joined, err := parties.create(deck.Name, playerName(req.Player), questions, answers, partyQuestionTime(deck))
+	if err != nil {
+		respondWithError(w, "Could not create room", err)
+		return
+	}
+	writePartyJSON(w, joined)
+}
+
+// partyJoinHandler adds a player to a room
+func partyJoinHandler(w http.ResponseWriter, r *http.Request) {
+	var req PartyRequest
+	if !readPartyRequest(w, r, &req) {
+		return
+	}
+	joined, err := parties.join(deckOf(r).Name, req.Code, playerName(req.Player))
+	if err != nil {
+		partyError(w, err)
+		return
+	}
+	writePartyJSON(w, joined)
+}
+
+// partyStartHandler lets the host ask the first question
+func partyStartHandler(w http.ResponseWriter, r *http.Request) {
+	var req PartyRequest
+	if !readPartyRequest(w, r, &req) {
+		return
+	}
+	if err := parties.start(deckOf(r).Name, req.Code, req.HostToken); err != nil {
+		partyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// partyNextHandler lets the host ask the next question
+func partyNextHandler(w http.ResponseWriter, r *http.Request) {
+	var req PartyRequest
+	if !readPartyRequest(w, r, &req) {
+		return
+	}
+	if err := parties.next(deckOf(r).Name, req.Code, req.HostToken); err != nil {
+		partyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// partyAnswerHandler records a player's answer to the open question; the
+// result comes with the scoreboard once the question closes
+func partyAnswerHandler(w http.ResponseWriter, r *http.Request) {
+	var req PartyRequest
+	if !readPartyRequest(w, r, &req) {
+		return
+	}
+	if req.Option == nil {
+		http.Error(w, "Missing option", http.StatusBadRequest)
+		return
+	}
+	stat, err := parties.answer(deckOf(r).Name, req.Code, req.PlayerID, req.Question, *req.Option)
+	if err != nil {
+		partyError(w, err)
+		return
+	}
+	if len(stat.Images) > 0 {
+		if err := stats.record(stat); err != nil {
+			log.Printf("Could not record answer in room %s: %v\n", req.Code, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// partyEventsHandler streams the events of a room to one of its players as
+// server-sent events: lobby, question, answers, scores and winner
+func partyEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	events, err := parties.subscribe(deckOf(r).Name, r.URL.Query().Get("code"), r.URL.Query().Get("player"))
+	if err != nil {
+		partyError(w, err)
+		return
+	}
+	defer bus.unsubscribe(events)
+	streamEvents(w, r, events)
+}
+
+// readPartyRequest decodes the body of a POST to a party endpoint,
+// answering the request itself if it is not one
+func readPartyRequest(w http.ResponseWriter, r *http.Request, req *PartyRequest) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "Invalid party payload", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// partyError sends the status matching an error of the party hub
+func partyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errRoomNotFound), errors.Is(err, errNotInRoom):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errNotHost):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, errRoomStarted), errors.Is(err, errRoomFull), errors.Is(err, errWrongPhase),
+		errors.Is(err, errQuestionClosed), errors.Is(err, errAlreadyAnswered), errors.Is(err, errQuestionOrder):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, errInvalidOption):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		respondWithError(w, "Party request failed", err)
+	}
+}
+
+// writePartyJSON sends v as JSON
+func writePartyJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		respondWithError(w, "Could not encode party response", err)
+	}
+}
+
+// partyQuestionTime is the time per question of party rooms of a deck
+func partyQuestionTime(deck *config.Deck) time.Duration {
+	if deck.QuestionTime > 0 {
+		return deck.QuestionTime
+	}
+	return defaultPartyQuestionTime
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// newTestRoom opens a room of two-option questions with the given correct
+// options, hosted by Alice
+func newTestRoom(t *testing.T, h *partyHub, limit time.Duration, correct ...int) PartyJoined {
+	t.Helper()
+	host, err := h.create(config.DefaultDeck, "Alice", twoWayQuestions(len(correct)), twoWay(correct...), limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host
+}
+
+// nextEvent returns the next event of the given name from a stream,
+// skipping others and failing if none arrives
+func nextEvent(t *testing.T, events <-chan busEvent, name string) busEvent {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.name == name {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Expected a %s event", name)
+			return busEvent{}
+		}
+	}
+}
+
+// TestPartyHubGame tests playing a room through to the end
+func TestPartyHubGame(t *testing.T) {
+	h := newPartyHub(time.Hour, newEventBus())
+	host := newTestRoom(t, h, time.Minute, 0, 1)
+	bob, err := h.join(config.DefaultDeck, strings.ToLower(host.Code), "Bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bob.HostToken != "" {
+		t.Error("Expected only the host to get the host token")
+	}
+
+	events, err := h.subscribe(config.DefaultDeck, host.Code, bob.PlayerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lobby := nextEvent(t, events, "lobby").data.(PartyLobby); len(lobby.Players) != 2 || lobby.Players[1] != "Bob" {
+		t.Errorf("Unexpected lobby: %+v", lobby)
+	}
+
+	if err := h.start(config.DefaultDeck, host.Code, "guess"); !errors.Is(err, errNotHost) {
+		t.Errorf("Expected errNotHost, got %v", err)
+	}
+	if err := h.start(config.DefaultDeck, host.Code, host.HostToken); err != nil {
+		t.Fatal(err)
+	}
+	if q := nextEvent(t, events, "question").data.(PartyQuestion); q.Index != 0 || q.Count != 2 || len(q.Options) != 2 {
+		t.Errorf("Unexpected question: %+v", q)
+	}
+	if _, err := h.join(config.DefaultDeck, host.Code, "Carol"); !errors.Is(err, errRoomStarted) {
+		t.Errorf("Expected errRoomStarted, got %v", err)
+	}
+
+	// The question closes once everyone has answered
+	if _, err := h.answer(config.DefaultDeck, host.Code, host.PlayerID, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if count := nextEvent(t, events, "answers").data.(PartyAnswers); count.Answered != 1 || count.Players != 2 {
+		t.Errorf("Unexpected answer count: %+v", count)
+	}
+	if _, err := h.answer(config.DefaultDeck, host.Code, host.PlayerID, 0, 0); !errors.Is(err, errAlreadyAnswered) {
+		t.Errorf("Expected errAlreadyAnswered, got %v", err)
+	}
+	if _, err := h.answer(config.DefaultDeck, host.Code, bob.PlayerID, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	scores := nextEvent(t, events, "scores").data.(PartyScoreboard)
+	if scores.Finished || scores.Answer != 0 || scores.Scores[0].Player != "Alice" || scores.Scores[0].Gained <= pointsPerAnswer/2 || scores.Scores[1].Points != 0 {
+		t.Errorf("Unexpected scoreboard: %+v", scores)
+	}
+	if _, err := h.answer(config.DefaultDeck, host.Code, bob.PlayerID, 0, 0); !errors.Is(err, errQuestionClosed) {
+		t.Errorf("Expected errQuestionClosed, got %v", err)
+	}
+
+	if err := h.next(config.DefaultDeck, host.Code, host.HostToken); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{host.PlayerID, bob.PlayerID} {
+		if _, err := h.answer(config.DefaultDeck, host.Code, p, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if scores := nextEvent(t, events, "scores").data.(PartyScoreboard); !scores.Finished || scores.Scores[0].Correct != 2 || scores.Scores[1].Correct != 1 {
+		t.Errorf("Unexpected final scoreboard: %+v", scores)
+	}
+	if winner := nextEvent(t, events, "winner").data.(PartyWinner); len(winner.Players) != 1 || winner.Players[0] != "Alice" {
+		t.Errorf("Unexpected winner: %+v", winner)
+	}
+	if err := h.next(config.DefaultDeck, host.Code, host.HostToken); !errors.Is(err, errWrongPhase) {
+		t.Errorf("Expected errWrongPhase, got %v", err)
+	}
+
+	// Reconnecting players catch up with the final scoreboard
+	events, err = h.subscribe(config.DefaultDeck, host.Code, bob.PlayerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := <-events; event.name != "lobby" {
+		t.Errorf("Expected the lobby first, got %s", event.name)
+	}
+	nextEvent(t, events, "scores")
+}
+
+// TestPartyHubExpire tests that questions close when their time is up
+func TestPartyHubExpire(t *testing.T) {
+	h := newPartyHub(time.Hour, newEventBus())
+	host := newTestRoom(t, h, time.Millisecond, 0, 1)
+	events, err := h.subscribe(config.DefaultDeck, host.Code, host.PlayerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.start(config.DefaultDeck, host.Code, host.HostToken); err != nil {
+		t.Fatal(err)
+	}
+	if scores := nextEvent(t, events, "scores").data.(PartyScoreboard); scores.Index != 0 || scores.Scores[0].Points != 0 {
+		t.Errorf("Unexpected scoreboard: %+v", scores)
+	}
+}
+
+// TestPartyHubErrors tests requests for rooms and players that do not exist
+func TestPartyHubErrors(t *testing.T) {
+	h := newPartyHub(time.Hour, newEventBus())
+	host := newTestRoom(t, h, time.Minute, 0)
+
+	if _, err := h.join("alice", host.Code, "Bob"); !errors.Is(err, errRoomNotFound) {
+		t.Errorf("Expected rooms to be per deck, got %v", err)
+	}
+	if _, err := h.subscribe(config.DefaultDeck, host.Code, "nope"); !errors.Is(err, errNotInRoom) {
+		t.Errorf("Expected errNotInRoom, got %v", err)
+	}
+	if _, err := h.answer(config.DefaultDeck, host.Code, host.PlayerID, 0, 0); !errors.Is(err, errQuestionClosed) {
+		t.Errorf("Expected errQuestionClosed before the start, got %v", err)
+	}
+	if err := h.next(config.DefaultDeck, host.Code, host.HostToken); !errors.Is(err, errWrongPhase) {
+		t.Errorf("Expected errWrongPhase, got %v", err)
+	}
+
+	// Expired rooms are closed for everyone following them
+	events, err := h.present(config.DefaultDeck, host.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.rooms[host.Code].createdAt = time.Now().Add(-2 * time.Hour)
+	newTestRoom(t, h, time.Minute, 0)
+	if _, ok := h.rooms[host.Code]; ok {
+		t.Error("Expected expired room to be pruned")
+	}
+	nextEvent(t, events, eventClosed)
+}
+
+// TestPartyHandlers tests creating, joining and following a room over HTTP
+func TestPartyHandlers(t *testing.T) {
+	choiceA, choiceB, ending := newTestCatalogDirs(t, 5, 5, 1)
+	c := newImageCatalog(choiceA, choiceB, ending, "")
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	catalogs = map[string]*imageCatalog{config.DefaultDeck: c}
+
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/party", bytes.NewBufferString(body)))
+		return w
+	}
+
+	w := post(partyCreateHandler, `{"player":"Alice"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var host PartyJoined
+	if err := json.NewDecoder(w.Body).Decode(&host); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(host.Code) != partyCodeLength || host.HostToken == "" || host.PlayerID == "" {
+		t.Errorf("Unexpected room: %+v", host)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+		status  int
+	}{
+		{"join unknown room", partyJoinHandler, `{"code":"NOPE","player":"Bob"}`, http.StatusNotFound},
+		{"join", partyJoinHandler, `{"code":"` + host.Code + `","player":"Bob"}`, http.StatusOK},
+		{"start as guest", partyStartHandler, `{"code":"` + host.Code + `"}`, http.StatusForbidden},
+		{"start", partyStartHandler, `{"code":"` + host.Code + `","hostToken":"` + host.HostToken + `"}`, http.StatusNoContent},
+		{"answer without option", partyAnswerHandler, `{"code":"` + host.Code + `","playerId":"` + host.PlayerID + `"}`, http.StatusBadRequest},
+		{"answer", partyAnswerHandler, `{"code":"` + host.Code + `","playerId":"` + host.PlayerID + `","question":0,"option":0}`, http.StatusNoContent},
+		{"answer twice", partyAnswerHandler, `{"code":"` + host.Code + `","playerId":"` + host.PlayerID + `","question":0,"option":0}`, http.StatusConflict},
+		{"malformed body", partyJoinHandler, `{`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := post(tt.handler, tt.body); w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// The event stream starts with the state of the room
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/party/events?code="+host.Code+"&player="+host.PlayerID, nil)
+	w = httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		partyEventsHandler(w, req.WithContext(ctx))
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", ct)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "event: lobby\ndata: ") || !strings.Contains(body, "event: question\n") {
+		t.Errorf("Unexpected events: %s", body)
+	}
+}
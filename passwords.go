@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// rotatedPassword is a game password set at runtime, which replaces the
+// configured password of its deck
+type rotatedPassword struct {
+	Password  string    `json:"password"`
+	RotatedAt time.Time `json:"rotatedAt"`
+	// SignedOutAt ends the sessions issued before it, if set
+	SignedOutAt time.Time `json:"signedOutAt,omitempty"`
+}
+
+// passwordStore holds the game passwords rotated through the admin console
+// by deck
+type passwordStore struct {
+	mu      sync.RWMutex
+	path    string
+	rotated map[string]rotatedPassword
+}
+
+// passwords keeps the rotated game passwords; main replaces it with one
+// backed by a file in the data directory
+var passwords = newPasswordStore()
+
+// newPasswordStore returns a password store that is only kept in memory
+func newPasswordStore() *passwordStore {
+	return &passwordStore{rotated: make(map[string]rotatedPassword)}
+}
+
+// openPasswordStore loads the passwords saved at path, creating its
+// directory if needed
+func openPasswordStore(path string) (*passwordStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	s := newPasswordStore()
+	s.path = path
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.rotated); err != nil {
+		return nil, err
+	}
+	if s.rotated == nil {
+		s.rotated = make(map[string]rotatedPassword)
+	}
+	return s, nil
+}
+
+// password returns the current game password of a deck
+func (s *passwordStore) password(deck *config.Deck) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p, ok := s.rotated[deck.Name]; ok {
+		return p.Password
+	}
+	return deck.APIAuth
+}
+
+// rotatedAt returns when the password of a deck was last rotated, or the
+// zero time if it is the configured one
+func (s *passwordStore) rotatedAt(deck string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rotated[deck].RotatedAt
+}
+
+// signedOutAt returns the time before which sessions of a deck are refused
+func (s *passwordStore) signedOutAt(deck string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rotated[deck].SignedOutAt
+}
+
+// rotate replaces the game password of a deck, saving it before it takes
+// effect. With signOut, everyone logged in to the deck has to log in again.
+func (s *passwordStore) rotate(deck, password string, signOut bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	p := rotatedPassword{Password: password, RotatedAt: now, SignedOutAt: s.rotated[deck].SignedOutAt}
+	if signOut {
+		p.SignedOutAt = now
+	}
+	rotated := maps.Clone(s.rotated)
+	rotated[deck] = p
+
+	if s.path != "" {
+		b, err := json.MarshalIndent(rotated, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.path, b, 0600); err != nil {
+			return err
+		}
+	}
+	s.rotated = rotated
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data, so that readers and
+// crashes see either the old or the new content
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// withPasswords replaces the rotated passwords for the duration of a test
+func withPasswords(t *testing.T, s *passwordStore) {
+	t.Helper()
+	saved := passwords
+	passwords = s
+	t.Cleanup(func() { passwords = saved })
+}
+
+// TestPasswordStore tests rotating passwords and loading them again
+func TestPasswordStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "passwords.json")
+	s, err := openPasswordStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deck := &config.Deck{Name: config.DefaultDeck, APIAuth: "configured"}
+	if got := s.password(deck); got != "configured" {
+		t.Errorf("Expected the configured password, got %q", got)
+	}
+
+	if err := s.rotate(config.DefaultDeck, "rotated", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.rotate(config.DefaultDeck, "again", false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the passwords to be saved privately, got %v, %v", info, err)
+	}
+
+	reopened, err := openPasswordStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.password(deck); got != "again" {
+		t.Errorf("Expected the rotated password after reopening, got %q", got)
+	}
+	if reopened.rotatedAt(config.DefaultDeck).IsZero() || reopened.signedOutAt(config.DefaultDeck).IsZero() {
+		t.Error("Expected rotating without signing out to keep the earlier sign-out")
+	}
+	if got := reopened.password(&config.Deck{Name: "alice", APIAuth: "alice"}); got != "alice" {
+		t.Errorf("Expected other decks to keep their password, got %q", got)
+	}
+}
+
+// TestSessionSignedOut tests that signing out refuses earlier sessions
+func TestSessionSignedOut(t *testing.T) {
+	withPasswords(t, newPasswordStore())
+	saved := sessions
+	t.Cleanup(func() { sessions = saved })
+	// Sessions are issued with a precision of seconds
+	sessions = newSessionSigner(randomSecret(), time.Hour)
+	sessions.now = func() time.Time { return time.Now().Add(-time.Minute) }
+	token, _, err := sessions.issue(config.DefaultDeck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/game-data", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	if err := passwords.rotate(config.DefaultDeck, "rotated", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessionFrom(req); err != nil {
+		t.Errorf("Expected the session to survive a rotation, got %v", err)
+	}
+
+	if err := passwords.rotate(config.DefaultDeck, "rotated", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessionFrom(req); err != errSessionExpired {
+		t.Errorf("Expected errSessionExpired after signing out, got %v", err)
+	}
+}
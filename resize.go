@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// imageWidths are the widths of the resized variants of images, smallest
+// first. Clients ask for a width with ?w= and get the smallest variant at
+// least that wide.
+var imageWidths = []int{400, 800, 1600}
+
+// jpegQuality is the quality of resized JPEG variants
+const jpegQuality = 85
+
+// maxResizePixels caps the size of the images that are resized. Decoding
+// takes several bytes per pixel, and a small file can declare a huge image.
+const maxResizePixels = 40_000_000
+
+// variantCache keeps resized variants of the game images on disk. A
+// variant is named after the size and modification time of its original,
+// so that changing the original invalidates it.
+type variantCache struct {
+	dir string
+
+	mu      sync.Mutex
+	pending map[string]*sync.WaitGroup // variants being generated by file
+}
+
+// variants caches the resized images of all decks; main replaces it with
+// one in the data directory. An empty dir disables resizing.
+var variants = newVariantCache("")
+
+func newVariantCache(dir string) *variantCache {
+	return &variantCache{dir: dir, pending: make(map[string]*sync.WaitGroup)}
+}
+
+// variantWidth returns the width of the variant serving a requested width,
+// or 0 for the original
+func variantWidth(requested int) int {
+	if requested <= 0 {
+		return 0
+	}
+	for _, w := range imageWidths {
+		if w >= requested {
+			return w
+		}
+	}
+	return imageWidths[len(imageWidths)-1]
+}
+
+// parseWidth reads the ?w= of an image request; anything but a positive
+// number asks for the original
+func parseWidth(s string) int {
+	w, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return variantWidth(w)
+}
+
+// path returns the file to serve for an image at the given width: a
+// resized variant, generated on first use, or the original if it is not
+// wider, cannot be resized or resizing fails
+func (c *variantCache) path(original string, width int) string {
+	if c.dir == "" || width == 0 {
+		return original
+	}
+	info, err := os.Stat(original)
+	if err != nil {
+		return original
+	}
+	dir, file := c.variantPath(original, info, width)
+	variant := filepath.Join(dir, file)
+	if _, err := os.Stat(variant); err == nil {
+		return variant
+	}
+
+	c.mu.Lock()
+	if wg, ok := c.pending[variant]; ok {
+		c.mu.Unlock()
+		wg.Wait()
+		return c.existing(variant, original)
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	c.pending[variant] = wg
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, variant)
+		c.mu.Unlock()
+		wg.Done()
+	}()
+
+	resized, err := resizeFile(original, width)
+	if err != nil {
+		log.Printf("Could not resize %s: %v\n", original, err)
+		return original
+	}
+	if resized == nil {
+		return original
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Println("Could not cache resized image:", err)
+		return original
+	}
+	c.removeStale(dir, file)
+	if err := writeFileAtomic(variant, resized, 0644); err != nil {
+		log.Println("Could not cache resized image:", err)
+		return original
+	}
+	return variant
+}
+
+// existing returns variant if it exists, or else original
+func (c *variantCache) existing(variant, original string) string {
+	if _, err := os.Stat(variant); err != nil {
+		return original
+	}
+	return variant
+}
+
+// variantPath returns the directory of the variants of an original and
+// the file name of its variant of the given width. Originals are told
+// apart by a hash of their path, and their versions by size and
+// modification time.
+func (c *variantCache) variantPath(original string, info os.FileInfo, width int) (string, string) {
+	sum := sha256.Sum256([]byte(filepath.Clean(original)))
+	ext := ".png"
+	if isJPEG(original) {
+		ext = ".jpg"
+	}
+	file := fmt.Sprintf("%d-%d-%d%s", width, info.Size(), info.ModTime().UnixNano(), ext)
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:8])), file
+}
+
+// removeStale deletes the variants made from earlier versions of an
+// original, keeping the other widths of the current version and any other
+// files, such as the temporary files of variants being written
+func (c *variantCache) removeStale(dir, current string) {
+	version, _ := variantVersion(current)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if v, ok := variantVersion(e.Name()); ok && v != version {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// variantVersion returns the size and modification time of the original
+// of a variant named <width>-<size>-<mtime>.<ext>, and false for names of
+// other files
+func variantVersion(name string) (string, bool) {
+	ext := filepath.Ext(name)
+	fields := strings.Split(strings.TrimSuffix(name, ext), "-")
+	if len(fields) != 3 || ext == "" {
+		return "", false
+	}
+	for _, f := range fields {
+		if _, err := strconv.ParseUint(f, 10, 64); err != nil {
+			return "", false
+		}
+	}
+	return fields[1] + "-" + fields[2] + ext, true
+}
+
+// forget deletes the variants of an original that was moved or deleted
+func (c *variantCache) forget(original string) {
+	if c.dir == "" {
+		return
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(original)))
+	if err := os.RemoveAll(filepath.Join(c.dir, hex.EncodeToString(sum[:8]))); err != nil {
+		log.Printf("Could not delete resized images of %s: %v\n", original, err)
+	}
+}
+
+// isJPEG reports whether a file is a JPEG by its extension
+func isJPEG(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+// resizeFile returns the image file at path scaled down to width, encoded
+// as JPEG for JPEG originals and as PNG otherwise. It returns nil if the
+// image is not wider than width or cannot be resized, such as animated
+// GIFs and formats without a decoder, and an error for images of more than
+// maxResizePixels.
+func resizeFile(path string, width int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil || cfg.Width <= width {
+		// Formats without a decoder, such as WebP, are served as they are
+		return nil, nil
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > maxResizePixels {
+		return nil, fmt.Errorf("image of %d×%d pixels is too large to resize", cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var src image.Image
+	if format == "gif" {
+		all, err := gif.DecodeAll(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(all.Image) > 1 {
+			return nil, nil
+		}
+		src = all.Image[0]
+	} else if src, _, err = image.Decode(f); err != nil {
+		return nil, err
+	}
+
+	height := max(1, cfg.Height*width/cfg.Width)
+	dst := resize(src, width, height)
+	var buf bytes.Buffer
+	if isJPEG(path) {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales src down to width × height by averaging the source pixels
+// that fall on each destination pixel
+func resize(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	sw, sh := b.Dx(), b.Dy()
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, max((y+1)*sh/height, y*sh/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, max((x+1)*sw/width, x*sw/width+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride+x0*4 : sy*rgba.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			p := dst.Pix[y*dst.Stride+x*4:]
+			for i := range sum {
+				p[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
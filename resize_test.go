@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// writeTestImage writes a width × height image in the format of the
+// extension of name
+func writeTestImage(t *testing.T, name string, width, height int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	var err error
+	switch filepath.Ext(name) {
+	case ".jpg":
+		err = jpeg.Encode(&buf, img, nil)
+	case ".gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestVariantWidth tests picking the variant for a requested width
+func TestVariantWidth(t *testing.T) {
+	tests := []struct {
+		w    string
+		want int
+	}{
+		{"", 0},
+		{"big", 0},
+		{"-5", 0},
+		{"1", 400},
+		{"400", 400},
+		{"401", 800},
+		{"1200", 1600},
+		{"4000", 1600},
+	}
+
+	for _, tt := range tests {
+		if got := parseWidth(tt.w); got != tt.want {
+			t.Errorf("parseWidth(%q) = %d, want %d", tt.w, got, tt.want)
+		}
+	}
+}
+
+// TestVariantVersion tests telling variants apart from other files
+func TestVariantVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		ok      bool
+	}{
+		{"400-5120-1718000000000000000.jpg", "5120-1718000000000000000.jpg", true},
+		{"1600-5120-1718000000000000000.png", "5120-1718000000000000000.png", true},
+		{".400-5120-1718000000000000000.jpg-839201", "", false},
+		{"400-5120.jpg", "", false},
+		{"400-5120-x.jpg", "", false},
+		{"400-5120-1718000000000000000", "", false},
+	}
+
+	for _, tt := range tests {
+		version, ok := variantVersion(tt.name)
+		if version != tt.version || ok != tt.ok {
+			t.Errorf("variantVersion(%q) = %q, %v, want %q, %v", tt.name, version, ok, tt.version, tt.ok)
+		}
+	}
+}
+
+// TestResizeFile tests which images are resized and to what size
+func TestResizeFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		width   int
+		height  int
+		resized bool
+		format  string
+	}{
+		{"wide.jpg", 1000, 500, true, "jpeg"},
+		{"wide.png", 1000, 500, true, "png"},
+		{"wide.gif", 1000, 10, true, "png"},
+		{"narrow.jpg", 300, 300, false, ""},
+		{"garbage.webp", 0, 0, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if tt.width > 0 {
+				writeTestImage(t, path, tt.width, tt.height)
+			} else if err := os.WriteFile(path, []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := resizeFile(path, 400)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (b != nil) != tt.resized {
+				t.Fatalf("Expected resized %v, got %d bytes", tt.resized, len(b))
+			}
+			if b == nil {
+				return
+			}
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.format || cfg.Width != 400 || cfg.Height != tt.height*400/tt.width {
+				t.Errorf("Expected a 400 wide %s, got %s of %dx%d", tt.format, format, cfg.Width, cfg.Height)
+			}
+		})
+	}
+}
+
+// TestResizeFileTooLarge tests that images declaring more pixels than
+// maxResizePixels are not decoded
+func TestResizeFileTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	// Declare 50000×50000 pixels in the header chunk, which follows the
+	// 8 byte signature, its length and its type
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[16:], 50000)
+	binary.BigEndian.PutUint32(b[20:], 50000)
+	binary.BigEndian.PutUint32(b[29:], crc32.ChecksumIEEE(b[12:29]))
+	path := filepath.Join(t.TempDir(), "bomb.png")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(b)); err != nil || cfg.Width != 50000 {
+		t.Fatalf("Expected a valid header of 50000 pixels wide, got %+v, %v", cfg, err)
+	}
+	if resized, err := resizeFile(path, 400); err == nil || resized != nil {
+		t.Errorf("Expected an error, got %d bytes", len(resized))
+	}
+	if got := newVariantCache(t.TempDir()).path(path, 400); got != path {
+		t.Errorf("Expected the original, got %s", got)
+	}
+}
+
+// TestResizeAverages tests that resizing averages the pixels it merges
+func TestResizeAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		src.Set(x, 0, color.RGBA{R: 200, A: 255})
+		src.Set(x, 1, color.RGBA{B: 100, A: 255})
+	}
+	dst := resize(src, 2, 1)
+	if got := dst.RGBAAt(1, 0); got != (color.RGBA{R: 100, B: 50, A: 255}) {
+		t.Errorf("Expected the average of the merged pixels, got %v", got)
+	}
+}
+
+// TestVariantCache tests caching variants and invalidating them when their
+// original changes
+func TestVariantCache(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "cake.jpg")
+	writeTestImage(t, original, 1000, 500)
+	c := newVariantCache(filepath.Join(dir, "variants"))
+
+	small := c.path(original, 400)
+	if small == original {
+		t.Fatal("Expected a resized variant")
+	}
+	if again := c.path(original, 400); again != small {
+		t.Errorf("Expected the cached variant, got %s", again)
+	}
+	if c.path(original, 1600) != original {
+		t.Error("Expected the original for widths it does not exceed")
+	}
+	if c.path(original, 0) != original || newVariantCache("").path(original, 400) != original {
+		t.Error("Expected the original without a width or cache")
+	}
+
+	// Changing the original replaces its variants, but not the temporary
+	// files of variants being written
+	writing := filepath.Join(filepath.Dir(small), ".800-1-2.jpg-12345")
+	if err := os.WriteFile(writing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeTestImage(t, original, 800, 800)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(original, later, later); err != nil {
+		t.Fatal(err)
+	}
+	replaced := c.path(original, 400)
+	if replaced == small {
+		t.Fatal("Expected a new variant for the changed original")
+	}
+	if _, err := os.Stat(small); !os.IsNotExist(err) {
+		t.Error("Expected the stale variant to be removed")
+	}
+	if _, err := os.Stat(writing); err != nil {
+		t.Errorf("Expected the temporary file to be kept, got %v", err)
+	}
+	if f, err := os.Open(replaced); err != nil {
+		t.Fatal(err)
+	} else {
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Height != 400 {
+			t.Errorf("Expected the variant of the new original, got %+v, %v", cfg, err)
+		}
+	}
+
+	c.forget(original)
+	if _, err := os.Stat(replaced); !os.IsNotExist(err) {
+		t.Error("Expected forgetting an original to remove its variants")
+	}
+}
+
+// TestImageHandlerWidth tests serving resized game images with ?w=
+func TestImageHandlerWidth(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "cake.jpg")
+	writeTestImage(t, original, 1000, 500)
+	saved := variants
+	variants = newVariantCache(filepath.Join(dir, "variants"))
+	t.Cleanup(func() { variants = saved })
+
+	g, err := games.create(config.DefaultDeck, "", 0, false, twoWay(0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := games.publish(g, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		width int
+	}{
+		{"", 1000},
+		{"?w=320", 400},
+		{"?w=700", 800},
+		{"?w=3000", 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			imageHandler(w, httptest.NewRequest(http.MethodGet, url+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			cfg, err := jpeg.DecodeConfig(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Width != tt.width {
+				t.Errorf("Expected width %d, got %d", tt.width, cfg.Width)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"whos-your-mate/config"
+)
+
+// checkIssue is a single finding of the self-check
+type checkIssue struct {
+	Fatal bool
+	Msg   string
+}
+
+// checkReport collects the findings of selfCheck
+type checkReport struct {
+	Issues []checkIssue
+	Counts map[string]int // usable images per checked directory label
+}
+
+func (r *checkReport) errorf(format string, args ...any) {
+	r.Issues = append(r.Issues, checkIssue{Fatal: true, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (r *checkReport) warnf(format string, args ...any) {
+	r.Issues = append(r.Issues, checkIssue{Msg: fmt.Sprintf(format, args...)})
+}
+
+// OK reports whether the check found no fatal issues
+func (r *checkReport) OK() bool {
+	for _, issue := range r.Issues {
+		if issue.Fatal {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the report for the terminal
+func (r *checkReport) String() string {
+	var b strings.Builder
+	for _, issue := range r.Issues {
+		level := "WARN "
+		if issue.Fatal {
+			level = "ERROR"
+		}
+		fmt.Fprintf(&b, "%s %s\n", level, issue.Msg)
+	}
+	if r.OK() {
+		b.WriteString("Self-check passed\n")
+	} else {
+		b.WriteString("Self-check failed\n")
+	}
+	return b.String()
+}
+
+// checkDir is an image directory to verify and the number of usable images
+// it needs to hold
+type checkDir struct {
+	Label string
+	Path  string
+	Min   int
+	// Fillable directories can be filled through the admin upload, so
+	// missing directories and too few images are only warnings
+	Fillable bool
+}
+
+// selfCheck verifies that every directory exists and holds enough readable,
+// non-empty supported images. Identical files are flagged: across
+// directories they would let a question show the same picture twice.
+func selfCheck(dirs []checkDir) *checkReport {
+	r := &checkReport{Counts: make(map[string]int)}
+	type located struct {
+		label string
+		path  string
+	}
+	bySize := make(map[int64][]located)
+
+	for _, d := range dirs {
+		info, err := os.Stat(d.Path)
+		if os.IsNotExist(err) && d.Fillable {
+			r.warnf("%s directory %s does not exist yet, upload images to it", d.Label, d.Path)
+			continue
+		}
+		if err != nil {
+			r.errorf("%s directory %s: %v", d.Label, d.Path, err)
+			continue
+		}
+		if !info.IsDir() {
+			r.errorf("%s directory %s is not a directory", d.Label, d.Path)
+			continue
+		}
+
+		images, err := loadImages(d.Path)
+		if err != nil {
+			r.errorf("%s directory %s: %v", d.Label, d.Path, err)
+			continue
+		}
+
+		meta, err := loadImageMeta(images)
+		for _, err := range errorList(err) {
+			r.errorf("%s metadata %v", d.Label, err)
+		}
+		for _, path := range images {
+			if tier := meta[path].Tier; tier != "" && !isTier(tier) {
+				r.errorf("%s image %s has tier %q, must be perfect, good or poor", d.Label, path, tier)
+			}
+		}
+
+		usable := 0
+		for _, path := range images {
+			size, err := checkImageFile(path)
+			switch {
+			case err != nil:
+				r.errorf("%s image %s is not readable: %v", d.Label, path, err)
+			case size == 0:
+				r.errorf("%s image %s is empty", d.Label, path)
+			default:
+				usable++
+				bySize[size] = append(bySize[size], located{d.Label, path})
+			}
+		}
+		r.Counts[d.Label] = usable
+
+		switch {
+		case usable >= d.Min:
+		case d.Fillable:
+			r.warnf("%s directory %s has %d usable images, upload at least %d", d.Label, d.Path, usable, d.Min)
+		default:
+			r.errorf("%s directory %s has %d usable images, need at least %d", d.Label, d.Path, usable, d.Min)
+		}
+	}
+
+	// Only files of equal size can be identical, so hash just those
+	for _, candidates := range bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		byHash := make(map[string][]located)
+		for _, c := range candidates {
+			sum, err := hashFile(c.path)
+			if err != nil {
+				continue // already reported as unreadable
+			}
+			byHash[sum] = append(byHash[sum], c)
+		}
+		for _, dups := range byHash {
+			if len(dups) < 2 {
+				continue
+			}
+			paths := make([]string, len(dups))
+			crossDir := false
+			for i, dup := range dups {
+				paths[i] = dup.path
+				crossDir = crossDir || dup.label != dups[0].label
+			}
+			if crossDir {
+				r.errorf("identical images in different directories: %s", strings.Join(paths, ", "))
+			} else {
+				r.warnf("duplicate %s images: %s", dups[0].label, strings.Join(paths, ", "))
+			}
+		}
+	}
+
+	return r
+}
+
+// checkImageFile opens path and reads from it, returning the file size
+func checkImageFile(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() == 0 {
+		return 0, nil
+	}
+	if _, err := f.Read(make([]byte, 1)); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// hashFile returns the hex SHA-256 of the file content
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// imageCheckDirs lists the image directories of a deck to verify. Labels
+// are prefixed with the deck name except for the default deck.
+func imageCheckDirs(deck *config.Deck) []checkDir {
+	prefix := ""
+	if deck.Name != config.DefaultDeck {
+		prefix = deck.Name + "/"
+	}
+	return []checkDir{
+		{Label: prefix + "choice_a", Path: filepath.Clean(deck.ChoiceAImgDir), Min: deck.QuestionCount},
+		{Label: prefix + "choice_b", Path: filepath.Clean(deck.ChoiceBImgDir), Min: deck.QuestionCount * (deck.OptionCount - 1)},
+		{Label: prefix + "ending", Path: filepath.Clean(deck.EndingImgDir), Min: 1},
+	}
+}
+
+// checkDecks runs selfCheck for every deck separately, so that decks may
+// share images, and merges the findings. With fillable, decks that lack
+// images only get warnings, since they can be filled through the admin
+// upload.
+func checkDecks(decks []*config.Deck, fillable bool) *checkReport {
+	merged := &checkReport{Counts: make(map[string]int)}
+	for _, deck := range decks {
+		dirs := imageCheckDirs(deck)
+		for i := range dirs {
+			dirs[i].Fillable = fillable
+		}
+		var manifestIssues checkReport
+		if deck.Manifest != "" {
+			m := checkManifest(&manifestIssues, deck)
+			if m != nil && m.Mode == manifestCurated {
+				// Curated games need no images beyond those of the manifest
+				dirs[0].Min, dirs[1].Min = 1, 1
+			}
+		}
+		r := selfCheck(dirs)
+		merged.Issues = append(merged.Issues, manifestIssues.Issues...)
+		merged.Issues = append(merged.Issues, r.Issues...)
+		for label, n := range r.Counts {
+			merged.Counts[label] = n
+		}
+	}
+	return merged
+}
+
+// checkManifest reports every problem of the manifest of a deck and returns
+// the manifest if it is valid
+func checkManifest(r *checkReport, deck *config.Deck) *questionManifest {
+	m, err := loadManifest(deck.Manifest, deck.ChoiceAImgDir, deck.ChoiceBImgDir)
+	if err != nil {
+		for _, err := range errorList(err) {
+			r.errorf("manifest %v", err)
+		}
+		return nil
+	}
+	if m.Mode == manifestCurated && len(m.Questions) < deck.QuestionCount {
+		r.warnf("manifest %s has %d questions, games of deck %s will be shorter than %d questions", deck.Manifest, len(m.Questions), deck.Name, deck.QuestionCount)
+	}
+	return m
+}
+
+// errorList splits an error made by errors.Join into its parts
+func errorList(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"whos-your-mate/config"
+)
+
+// writeImage writes a test image file, creating its directory
+func writeImage(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestSelfCheckPasses tests a well-formed set of directories
+func TestSelfCheckPasses(t *testing.T) {
+	choiceA, choiceB, ending := newTestCatalogDirs(t, 3, 3, 1)
+	// newTestCatalogDirs writes identical content, so make every file unique
+	for _, dir := range []string{choiceA, choiceB, ending} {
+		images, err := loadImages(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, img := range images {
+			writeImage(t, img, img)
+		}
+	}
+
+	report := selfCheck(imageCheckDirs(&config.Deck{
+		Name:          config.DefaultDeck,
+		ChoiceAImgDir: choiceA,
+		ChoiceBImgDir: choiceB,
+		EndingImgDir:  ending,
+		QuestionCount: 3,
+		OptionCount:   2,
+	}))
+	if !report.OK() || len(report.Issues) != 0 {
+		t.Fatalf("Expected clean report, got:\n%s", report)
+	}
+	if report.Counts["choice_a"] != 3 || report.Counts["ending"] != 1 {
+		t.Errorf("Unexpected counts: %v", report.Counts)
+	}
+	if !strings.Contains(report.String(), "Self-check passed") {
+		t.Errorf("Expected passing summary, got:\n%s", report)
+	}
+}
+
+// TestSelfCheckFindings tests that every kind of problem is reported
+func TestSelfCheckFindings(t *testing.T) {
+	root := t.TempDir()
+	choiceA := filepath.Join(root, "choice_a")
+	choiceB := filepath.Join(root, "choice_b")
+	ending := filepath.Join(root, "missing")
+
+	writeImage(t, filepath.Join(choiceA, "a1.jpg"), "same")
+	writeImage(t, filepath.Join(choiceA, "a2.jpg"), "")
+	writeImage(t, filepath.Join(choiceA, "notes.txt"), "ignored")
+	writeImage(t, filepath.Join(choiceB, "b1.jpg"), "same")
+	writeImage(t, filepath.Join(choiceB, "b2.jpg"), "twin")
+	writeImage(t, filepath.Join(choiceB, "b3.jpg"), "twin")
+	writeImage(t, filepath.Join(choiceB, "b3.jpg.json"), `{"tier": "great"}`)
+
+	report := selfCheck(imageCheckDirs(&config.Deck{
+		Name:          config.DefaultDeck,
+		ChoiceAImgDir: choiceA,
+		ChoiceBImgDir: choiceB,
+		EndingImgDir:  ending,
+		QuestionCount: 2,
+		OptionCount:   2,
+	}))
+	if report.OK() {
+		t.Fatal("Expected the self-check to fail")
+	}
+
+	out := report.String()
+	for _, want := range []string{
+		"ERROR choice_a image " + filepath.Join(choiceA, "a2.jpg") + " is empty",
+		"ERROR choice_a directory " + choiceA + " has 1 usable images, need at least 2",
+		"ERROR ending directory " + ending,
+		"ERROR identical images in different directories",
+		"WARN  duplicate choice_b images",
+		"ERROR choice_b image " + filepath.Join(choiceB, "b3.jpg") + ` has tier "great"`,
+		"Self-check failed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected report to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+// TestSelfCheckNotADirectory tests a directory path pointing at a file
+func TestSelfCheckNotADirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.jpg")
+	writeImage(t, path, "content")
+
+	report := selfCheck([]checkDir{{Label: "choice_a", Path: path, Min: 1}})
+	if report.OK() || !strings.Contains(report.String(), "is not a directory") {
+		t.Errorf("Expected not a directory error, got:\n%s", report)
+	}
+}
+
+// TestImageCheckDirsOptionCount tests that every wrong option needs its own image
+func TestImageCheckDirsOptionCount(t *testing.T) {
+	dirs := imageCheckDirs(&config.Deck{Name: "alice", QuestionCount: 5, OptionCount: 4})
+	if dirs[0].Label != "alice/choice_a" || dirs[0].Min != 5 {
+		t.Errorf("Unexpected choice_a check: %+v", dirs[0])
+	}
+	if dirs[1].Label != "alice/choice_b" || dirs[1].Min != 15 {
+		t.Errorf("Expected choice_b to need 15 images, got %+v", dirs[1])
+	}
+}
+
+// TestCheckDecksManifest tests that manifest problems fail the self-check
+// and that curated decks need no spare images
+func TestCheckDecksManifest(t *testing.T) {
+	root := t.TempDir()
+	choiceA, choiceB, ending := filepath.Join(root, "choice_a"), filepath.Join(root, "choice_b"), filepath.Join(root, "ending")
+	writeImage(t, filepath.Join(choiceA, "imga.jpg"), "a")
+	writeImage(t, filepath.Join(choiceB, "other.jpg"), "b")
+	writeImage(t, filepath.Join(ending, "end.jpg"), "end")
+	deck := &config.Deck{
+		Name:          config.DefaultDeck,
+		ChoiceAImgDir: choiceA,
+		ChoiceBImgDir: choiceB,
+		EndingImgDir:  ending,
+		QuestionCount: 3,
+		OptionCount:   2,
+		Manifest:      writeManifest(t, t.TempDir(), `{"questions": [{"correct": "imga.jpg", "wrong": ["other.jpg"]}]}`),
+	}
+
+	report := checkDecks([]*config.Deck{deck}, false)
+	if !report.OK() {
+		t.Fatalf("Expected curated deck to pass, got:\n%s", report)
+	}
+	if !strings.Contains(report.String(), "WARN  manifest "+deck.Manifest+" has 1 questions") {
+		t.Errorf("Expected short game warning, got:\n%s", report)
+	}
+
+	deck.Manifest = writeManifest(t, t.TempDir(), `{"questions": [{"correct": "gone.jpg", "wrong": ["other.jpg"]}]}`)
+	report = checkDecks([]*config.Deck{deck}, false)
+	if report.OK() || !strings.Contains(report.String(), `ERROR manifest `+deck.Manifest+`: question 1: correct image "gone.jpg"`) {
+		t.Errorf("Expected missing image error, got:\n%s", report)
+	}
+}
+
+// TestCheckDecksFillable tests that decks without images only get warnings
+// while they can be filled through the admin upload
+func TestCheckDecksFillable(t *testing.T) {
+	root := t.TempDir()
+	writeImage(t, filepath.Join(root, "choice_a", "imga.jpg"), "a")
+	deck := &config.Deck{
+		Name:          config.DefaultDeck,
+		ChoiceAImgDir: filepath.Join(root, "choice_a"),
+		ChoiceBImgDir: filepath.Join(root, "choice_b"),
+		EndingImgDir:  filepath.Join(root, "ending"),
+		QuestionCount: 3,
+		OptionCount:   2,
+	}
+
+	if report := checkDecks([]*config.Deck{deck}, false); report.OK() {
+		t.Errorf("Expected missing images to fail the check, got:\n%s", report)
+	}
+	report := checkDecks([]*config.Deck{deck}, true)
+	if !report.OK() || len(report.Issues) != 3 {
+		t.Fatalf("Expected three warnings, got:\n%s", report)
+	}
+	if !strings.Contains(report.String(), "WARN  choice_a directory "+deck.ChoiceAImgDir+" has 1 usable images, upload at least 3") {
+		t.Errorf("Expected a warning about too few images, got:\n%s", report)
+	}
+
+	// Broken images still fail the check
+	writeImage(t, filepath.Join(root, "ending", "empty.jpg"), "")
+	if report := checkDecks([]*config.Deck{deck}, true); report.OK() {
+		t.Errorf("Expected an empty image to fail the check, got:\n%s", report)
+	}
+}
@@ -3,8 +3,9 @@ import { loadConfig } from './configLoader.js';
 import {
     initGameUtils,
     getRandomLoadingText, getRandomWishLine,
-    setQuery, query,
-    fetchGameData, preloadImages, sleep,
+    login, sharedSeed, difficulty, mode, challengeLink,
+    fetchGameData, fetchLeaderboard, nextQuestion, submitAnswer, preloadImages, sleep,
+    setImageSource, OPTION_SIZES,
     startConfettiAnimation, startHeartAnimation
 } from './gameUtils.js';
 
@@ -12,6 +13,7 @@ import {
 const App = {
     elements: {},
     countdownInterval: null,
+    questionTimer: null,
 
     async init() {
         this.config = await loadConfig();
@@ -42,14 +44,18 @@ const App = {
             password: document.getElementById('password'),
             passwordErrMsg: document.getElementById('error-message'),
             passwordInput: document.getElementById('password-input'),
+            playerInput: document.getElementById('player-input'),
             // Game Page
             gamePage: document.getElementById('game-page'),
-            option1: document.getElementById('option1'),
-            option2: document.getElementById('option2'),
+            questionTimer: document.getElementById('question-timer'),
+            question: document.getElementById('question'),
+            options: document.getElementById('options'),
             // End Page
             endPage: document.getElementById('end-page'),
             endMessage: document.getElementById('message'),
             endGroupPhoto: document.getElementById('group-photo'),
+            leaderboard: document.getElementById('leaderboard'),
+            challengeLink: document.getElementById('challenge-link'),
             backToStartBtn: document.getElementById('back-to-start')
         };
     },
@@ -121,6 +127,7 @@ const App = {
 
     showPasswordInput() {
         this.elements.passwordErrMsg.textContent = '';
+        this.elements.playerInput.value = localStorage.getItem('player') || '';
         this.elements.password.classList.remove('d-none');
         this.elements.startGame.classList.add('d-none');
     },
@@ -136,67 +143,179 @@ const App = {
     },
 
     async setPassword() {
-        setQuery(this.elements.passwordInput.value);
         try {
             this.showLoadingPage();
+            await login(this.elements.passwordInput.value);
+            this.elements.passwordInput.value = '';
+            const player = this.elements.playerInput.value.trim();
+            localStorage.setItem('player', player);
             /** @type {import('./gameUtils.js').GameData} */
-            const gameData = await fetchGameData();
+            const gameData = await fetchGameData(player, sharedSeed, difficulty, mode);
+            this.elements.challengeLink.href = challengeLink(gameData.seed);
             preloadImages(gameData);
-            this.loadQuestion(gameData, 0);
+            const speed = gameData.mode === 'speed';
+            if (!speed) this.loadQuestion(gameData, 0);
             await sleep(1000);
             this.hideLoadingPage();
             this.rollbackShowPasswordInput();
             this.pageLoading2PageHome();
+            // The clock of a speed question starts as soon as it is asked
+            if (speed) this.loadQuestion(gameData, 0);
         } catch (error) {
             this.hideLoadingPage();
-            this.elements.passwordErrMsg.textContent = 'Error loading game data. The password might be wrong!';
+            this.elements.passwordErrMsg.textContent = error.rateLimited
+                ? error.message
+                : 'Error loading game data. The password might be wrong!';
         }
     },
 
     loadQuestion(gameData, currentQuestion) {
-        if (currentQuestion < gameData.questions.length) {
-            const question = gameData.questions[currentQuestion];
-            this.elements.option1.src = question.img1 + query;
-            this.elements.option2.src = question.img2 + query;
-            this.elements.option1.onclick = () => this.checkAnswer(gameData, currentQuestion, 1);
-            this.elements.option2.onclick = () => this.checkAnswer(gameData, currentQuestion, 2);
+        if (currentQuestion >= gameData.questionCount) {
+            this.endGame();
+        } else if (gameData.mode === 'speed') {
+            this.askQuestion(gameData, currentQuestion);
         } else {
-            this.endGame(gameData, true);
+            this.showQuestion(gameData, currentQuestion, gameData.questions[currentQuestion]);
         }
     },
 
-    checkAnswer(gameData, currentQuestion, selectedOption) {
-        const correctOption = gameData.questions[currentQuestion].correct;
-        if (selectedOption === correctOption) {
-            this.loadQuestion(gameData, currentQuestion + 1);
-        } else {
-            this.endGame(gameData, false);
+    // Asks the next question of a speed game and runs its clock
+    async askQuestion(gameData, currentQuestion) {
+        try {
+            /** @type {import('./gameUtils.js').NextQuestion} */
+            const question = await nextQuestion(gameData.gameId);
+            this.showQuestion(gameData, currentQuestion, question);
+            this.startQuestionTimer(gameData, currentQuestion, question.remainingMs);
+        } catch (error) {
+            this.endGame();
+        }
+    },
+
+    showQuestion(gameData, currentQuestion, question) {
+        this.elements.question.textContent = question.caption || '';
+        const options = this.elements.options;
+        options.classList.toggle('options-grid', question.options.length > 2);
+        options.replaceChildren(...question.options.map((src, i) => {
+            const cell = document.createElement('div');
+            cell.className = `d-flex justify-content-${i % 2 === 0 ? 'end' : 'start'}`;
+            const figure = document.createElement('figure');
+            figure.className = 'm-0';
+            const img = document.createElement('img');
+            img.className = 'm-2';
+            setImageSource(img, src, OPTION_SIZES);
+            img.onclick = () => this.checkAnswer(gameData, currentQuestion, i);
+            figure.appendChild(img);
+            cell.appendChild(figure);
+            return cell;
+        }));
+    },
+
+    // Counts down the time left and gives up the question once it runs out
+    startQuestionTimer(gameData, currentQuestion, remainingMs) {
+        const deadline = Date.now() + remainingMs;
+        const timer = this.elements.questionTimer;
+        const update = () => {
+            const timeLeft = deadline - Date.now();
+            timer.textContent = `⏱ ${Math.max(Math.ceil(timeLeft / 1000), 0)}s`;
+            if (timeLeft <= 0) this.checkAnswer(gameData, currentQuestion, null);
+        };
+        timer.classList.remove('d-none');
+        this.questionTimer = setInterval(update, 100);
+        update();
+    },
+
+    stopQuestionTimer() {
+        clearInterval(this.questionTimer);
+        this.questionTimer = null;
+        this.elements.questionTimer.classList.add('d-none');
+    },
+
+    /**
+     * @param {number|null} selectedOption null if the time ran out
+     */
+    async checkAnswer(gameData, currentQuestion, selectedOption) {
+        this.stopQuestionTimer();
+        this.elements.options.querySelectorAll('img').forEach(img => img.onclick = null);
+        try {
+            /** @type {import('./gameUtils.js').AnswerResponse} */
+            const answer = await submitAnswer(gameData.gameId, currentQuestion, selectedOption);
+            if (answer.late || selectedOption === null) this.elements.question.textContent = 'Too slow! ⏱';
+            if (answer.reveal) await this.showReveal(answer);
+            if (answer.finished) {
+                this.endGame(answer.result);
+            } else {
+                this.loadQuestion(gameData, currentQuestion + 1);
+            }
+        } catch (error) {
+            this.endGame();
         }
     },
 
+    // Shows what is known about the images of the answered question
+    async showReveal(answer) {
+        this.elements.options.querySelectorAll('figure').forEach((figure, i) => {
+            figure.classList.toggle('option-correct', i === answer.answer);
+            const meta = answer.reveal[i];
+            const lines = [meta.reveal, meta.caption, meta.credit && `📷 ${meta.credit}`].filter(Boolean);
+            if (lines.length === 0) return;
+            const caption = document.createElement('figcaption');
+            caption.className = 'option-caption';
+            caption.textContent = lines.join(' · ');
+            figure.appendChild(caption);
+        });
+        await sleep(2500);
+    },
+
     pageGame2PageEnd() {
         this.elements.gamePage.classList.add('d-none');
         this.elements.endPage.classList.remove('d-none');
-        this.elements.option1.removeAttribute("src");
-        this.elements.option2.removeAttribute("src");
+        this.elements.question.textContent = '';
+        this.elements.options.replaceChildren();
     },
 
-    endGame(gameData, won) {
-        if (won) {
+    /**
+     * @param {import('./gameUtils.js').GameResult} [result] missing if the game broke off
+     */
+    endGame(result) {
+        if (result?.won) {
             this.elements.title.textContent = 'Happy Birthday 🎂';
             this.elements.endMessage.textContent = getRandomWishLine();
             this.elements.endMessage.classList.remove('d-none');
-            this.elements.endGroupPhoto.src = gameData.endingPhoto + query;
-            this.elements.endGroupPhoto.classList.remove('d-none');
             startConfettiAnimation();
         } else {
             this.elements.title.textContent = 'Oops! 💩';
             this.elements.endMessage.classList.add('d-none');
+        }
+        // The server picks the ending photo for the score tier, if there is one
+        if (result?.endingPhoto) {
+            setImageSource(this.elements.endGroupPhoto, result.endingPhoto, '100vw');
+            this.elements.endGroupPhoto.classList.remove('d-none');
+        } else {
             this.elements.endGroupPhoto.classList.add('d-none');
         }
+        this.showLeaderboard();
         this.pageGame2PageEnd();
     },
 
+    async showLeaderboard() {
+        const list = this.elements.leaderboard;
+        list.replaceChildren();
+        list.classList.add('d-none');
+        try {
+            /** @type {import('./gameUtils.js').Leaderboard} */
+            const leaderboard = await fetchLeaderboard(5, mode);
+            leaderboard.entries.forEach(entry => {
+                const item = document.createElement('li');
+                const seconds = (entry.timeTakenMs / 1000).toFixed(1);
+                item.textContent = `${entry.rank}. ${entry.player} · ${entry.points} pts · ${entry.correct}/${entry.total} in ${seconds}s`;
+                list.appendChild(item);
+            });
+            list.classList.toggle('d-none', leaderboard.entries.length === 0);
+        } catch (error) {
+            // The leaderboard is optional, the end page works without it
+        }
+    },
+
     pageEnd2PageHome() {
         this.elements.title.textContent = this.config.APP_TITLE;
         this.elements.endPage.classList.add('d-none');
@@ -1,11 +1,38 @@
+// Maps the texts of a server-side deck onto the frontend config keys
+const deckTextKeys = {
+    title: 'APP_TITLE',
+    madeBy: 'MADE_BY',
+    specialPerson: 'SPECIAL_PERSON',
+    specialDay: 'SPECIAL_DAY',
+    wishLines: 'WISH_LINES',
+    loadingTexts: 'LOADING_TEXTS'
+};
+
+const loadDeckTexts = async () => {
+    try {
+        const response = await fetch('deck' + location.search);
+        if (!response.ok) return {};
+        const { texts = {} } = await response.json();
+        const overrides = {};
+        for (const [key, configKey] of Object.entries(deckTextKeys)) {
+            if (texts[key]) overrides[configKey] = texts[key];
+        }
+        return overrides;
+    } catch (err) {
+        console.warn("deck texts not available, using local config");
+        return {};
+    }
+};
+
 export const loadConfig = async () => {
     const exampleConfig = await import('./config.example.js');
+    const deckTexts = await loadDeckTexts();
 
     try {
         const userConfig = await import('./config.js');
-        return { ...exampleConfig, ...userConfig };
+        return { ...exampleConfig, ...userConfig, ...deckTexts };
     } catch (err) {
         console.warn("config.js not found, using default config.example.js");
-        return exampleConfig;
+        return { ...exampleConfig, ...deckTexts };
     }
 };
\ No newline at end of file
@@ -15,43 +15,287 @@ export const getRandomLoadingText = () =>
 
 export const sleep = ms => new Promise(resolve => setTimeout(resolve, ms));
 
-export let query = "?auth=";
-export const setQuery = password => { query = "?auth=" + password; };
+// Deck addressed by a /d/<deck>/ URL, empty for the default deck
+export const deck = (location.pathname.match(/^\/d\/([^/]+)\//) || [])[1] || '';
+
+// Query string selecting the deck; the session itself travels in a cookie
+export const query = deck ? `?deck=${encodeURIComponent(deck)}` : '';
+
+/**
+ * Exchanges the password for a session cookie
+ * @param {string} password
+ */
+export const login = async password => {
+    const response = await fetch('login' + query, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ password })
+    });
+    if (response.status === 429) {
+        const error = new Error(`Too many attempts, try again in ${response.headers.get('Retry-After')}s`);
+        error.rateLimited = true;
+        throw error;
+    }
+    if (!response.ok) throw new Error('Login failed');
+};
+
+// Version of the game data contract this frontend understands
+export const GAME_DATA_VERSION = 3;
 
 /**
  * @typedef {Object} Question
- * @property {string} questionText
- * @property {string[]} choices
- * @property {number} correctIndex
+ * @property {string[]} options image URLs, one of them correct
+ * @property {string} [caption] shown above the options of curated questions
+ * @property {string} [difficulty] easy, medium or hard
  */
 
 /**
  * @typedef {Object} GameData
- * @property {Question[]} questions
- * @property {string} endingPhoto
+ * @property {number} version
+ * @property {string} gameId
+ * @property {number} seed replays the same questions when passed back
+ * @property {string} [mode] speed for games asked one question at a time
+ * @property {number} questionCount
+ * @property {number} [timeLimitMs] per question of a speed game
+ * @property {Question[]} [questions] missing in speed games
+ */
+
+/**
+ * @typedef {Object} NextQuestion a question of a speed game
+ * @property {number} index
+ * @property {string[]} options
+ * @property {string} [caption]
+ * @property {string} [difficulty]
+ * @property {number} timeLimitMs
+ * @property {number} remainingMs until the server stops accepting the answer
+ */
+
+/**
+ * @typedef {Object} GameResult
+ * @property {number} correct
+ * @property {number} total
+ * @property {boolean} won
+ * @property {number} points
+ * @property {number} timeTakenMs
+ * @property {string} tier perfect, good or poor
+ * @property {string} [endingPhoto] picked for the tier, if the deck has one
+ */
+
+/**
+ * @typedef {Object} ImageMeta
+ * @property {string} [caption]
+ * @property {string} [reveal]
+ * @property {string} [credit]
+ */
+
+/**
+ * @typedef {Object} AnswerResponse
+ * @property {boolean} correct
+ * @property {boolean} [late] the time of the question had run out
+ * @property {number} points scored by this answer
+ * @property {number} answer index of the correct option
+ * @property {ImageMeta[]} [reveal] metadata of each option
+ * @property {boolean} finished
+ * @property {GameResult} [result]
+ */
+
+/**
+ * @typedef {Object} RankedScore
+ * @property {number} rank
+ * @property {string} player
+ * @property {number} correct
+ * @property {number} total
+ * @property {boolean} won
+ * @property {number} points
+ * @property {number} timeTakenMs
+ * @property {string} finishedAt
+ */
+
+/**
+ * @typedef {Object} Leaderboard
+ * @property {string} deck
+ * @property {string} [day]
+ * @property {RankedScore[]} entries
+ */
+
+/**
+ * @typedef {Object} PartyJoined
+ * @property {string} code of the room
+ * @property {string} playerId identifies the player's answers and events
+ * @property {string} [hostToken] only given to the host
+ */
+
+/**
+ * @typedef {Object} PartyLobby
+ * @property {string} code
+ * @property {string} phase lobby, question, scores or finished
+ * @property {string[]} players
+ */
+
+/**
+ * @typedef {Object} PartyQuestion
+ * @property {number} index
+ * @property {number} count
+ * @property {string[]} options
+ * @property {string} [caption]
+ * @property {number} timeLimitMs
+ * @property {number} remainingMs
+ */
+
+/**
+ * @typedef {Object} PartyScoreboard
+ * @property {number} index of the question that closed
+ * @property {number} count
+ * @property {number} answer index of the correct option
+ * @property {ImageMeta[]} [reveal]
+ * @property {boolean} finished
+ * @property {{player: string, points: number, correct: number, gained: number}[]} scores best first
+ */
+
+/**
+ * @typedef {Object} PartyAnswers
+ * @property {number} index of the open question
+ * @property {number} answered
+ * @property {number} players
+ */
+
+/**
+ * @typedef {Object} PartyWinner
+ * @property {string[]} players tied for first place
+ * @property {number} points
+ */
+
+/**
+ * @typedef {Object} SoloStarted
+ * @property {string} player
+ * @property {string} [mode]
+ * @property {number} questionCount
+ */
+
+/**
+ * @typedef {Object} ScoreEntry
+ * @property {string} player
+ * @property {string} deck
+ * @property {string} [mode]
+ * @property {number} correct
+ * @property {number} total
+ * @property {boolean} won
+ * @property {number} points
+ * @property {number} timeTakenMs
+ * @property {string} finishedAt
+ */
+
+// Appends parameters to the deck query string, leaving out empty ones
+export const withParams = params => {
+    const search = new URLSearchParams(query);
+    Object.entries(params).forEach(([key, value]) => {
+        if (value != null && value !== '') search.set(key, value);
+    });
+    return '?' + search.toString();
+};
+
+// Widths of the resized variants the server makes of every image
+export const IMAGE_WIDTHS = [400, 800, 1600];
+
+// How wide answer options are shown, for picking their variant
+export const OPTION_SIZES = '(min-width: 768px) 40vw, 32vh';
+
+// Shows a game image, letting the browser download the smallest variant
+// that fits how wide it is shown
+export const setImageSource = (img, src, sizes) => {
+    img.sizes = sizes;
+    img.srcset = IMAGE_WIDTHS.map(w => `${src}${withParams({ w })} ${w}w`).join(', ');
+    img.src = src + withParams({ w: IMAGE_WIDTHS[1] });
+};
+
+const pageParams = new URLSearchParams(location.search);
+
+// Seed of a round shared through a challenge link, if any
+export const sharedSeed = pageParams.get('seed');
+
+// Difficulty asked for with ?difficulty=easy or ?difficulty=hard, if any
+export const difficulty = pageParams.get('difficulty');
+
+// Game mode asked for with ?mode=speed, if any
+export const mode = pageParams.get('mode');
+
+/**
+ * Returns a link that replays the round of the given seed
+ * @param {number} seed
  */
+export const challengeLink = seed => {
+    const url = new URL(location.href);
+    url.searchParams.set('seed', seed);
+    return url.toString();
+};
 
 /**
+ * @param {string} player name recorded on the leaderboard
+ * @param {string} [seed] replays the round of an earlier game
+ * @param {string} [difficulty] easy or hard
+ * @param {string} [mode] speed
  * @returns {Promise<GameData>}
  */
-export const fetchGameData = async () => {
-    const response = await fetch('/game-data' + query);
+export const fetchGameData = async (player, seed, difficulty, mode) => {
+    const response = await fetch('game-data' + withParams({ player, seed, difficulty, mode }));
+    if (!response.ok) throw new Error('Network response was not ok');
+    const gameData = await response.json();
+    if (gameData.version !== GAME_DATA_VERSION) throw new Error(`Unsupported game data version ${gameData.version}`);
+    return gameData;
+};
+
+/**
+ * Asks the current question of a speed game, starting its clock
+ * @param {string} gameId
+ * @returns {Promise<NextQuestion>}
+ */
+export const nextQuestion = async gameId => {
+    const response = await fetch('next' + query, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ gameId })
+    });
+    if (!response.ok) throw new Error('Network response was not ok');
+    return await response.json();
+};
+
+/**
+ * @param {string} gameId
+ * @param {number} question
+ * @param {number|null} option index into the question's options, null to
+ * give up a question of a speed game whose time ran out
+ * @returns {Promise<AnswerResponse>}
+ */
+export const submitAnswer = async (gameId, question, option) => {
+    const response = await fetch('answer' + query, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(option === null ? { gameId, question, giveUp: true } : { gameId, question, option })
+    });
+    if (!response.ok) throw new Error('Network response was not ok');
+    return await response.json();
+};
+
+/**
+ * @param {number} limit
+ * @param {string} [mode] speed for the board of speed games
+ * @returns {Promise<Leaderboard>}
+ */
+export const fetchLeaderboard = async (limit, mode) => {
+    const response = await fetch('leaderboard' + withParams({ limit, mode }));
     if (!response.ok) throw new Error('Network response was not ok');
     return await response.json();
 };
 
 export const preloadImages = gameData => {
     const preloadContainer = document.getElementById('preload-images');
-    gameData.questions.forEach(q => {
-        ['img1', 'img2'].forEach(imgKey => {
+    (gameData.questions || []).forEach(q => {
+        q.options.forEach(option => {
             const img = document.createElement('img');
-            img.src = q[imgKey] + query;
+            setImageSource(img, option, OPTION_SIZES);
             preloadContainer.appendChild(img);
         });
     });
-    const endingPhoto = document.createElement('img');
-    endingPhoto.src = gameData.endingPhoto + query;
-    preloadContainer.appendChild(endingPhoto);
 };
 
 export const startConfettiAnimation = () => {
@@ -0,0 +1,198 @@
+// Party mode: everyone in a room answers the same questions at once
+import { loadConfig } from './configLoader.js';
+import { login, query, setImageSource, withParams, OPTION_SIZES } from './gameUtils.js';
+
+const Party = {
+    elements: {},
+    /** @type {import('./gameUtils.js').PartyJoined} */
+    room: null,
+    events: null,
+    questionTimer: null,
+    questionIndex: -1,
+    picked: null,
+
+    async init() {
+        this.config = await loadConfig();
+        this.cacheElements();
+        this.bindEvents();
+        this.elements.title.textContent = `${this.config.APP_TITLE} 🎉`;
+        this.elements.playerInput.value = localStorage.getItem('player') || '';
+        this.elements.codeInput.value = new URLSearchParams(location.search).get('code') || '';
+        if (localStorage.getItem('theme') === 'dark') document.body.classList.add('theme-dark');
+    },
+
+    cacheElements() {
+        const byId = id => document.getElementById(id);
+        this.elements = {
+            title: byId('game-title'),
+            // Join Page
+            joinPage: byId('join-page'),
+            errorMessage: byId('error-message'),
+            playerInput: byId('player-input'),
+            passwordInput: byId('password-input'),
+            codeInput: byId('code-input'),
+            joinRoom: byId('join-room'),
+            hostRoom: byId('host-room'),
+            // Lobby Page
+            lobbyPage: byId('lobby-page'),
+            roomCode: byId('room-code'),
+            lobbyPlayers: byId('lobby-players'),
+            startParty: byId('start-party'),
+            lobbyWaiting: byId('lobby-waiting'),
+            presentLink: byId('present-link'),
+            // Game Page
+            gamePage: byId('game-page'),
+            questionTimer: byId('question-timer'),
+            question: byId('question'),
+            options: byId('options'),
+            // Scores Page
+            scoresPage: byId('scores-page'),
+            answerResult: byId('answer-result'),
+            scoreboard: byId('scoreboard'),
+            nextQuestion: byId('next-question')
+        };
+    },
+
+    bindEvents() {
+        this.elements.joinRoom.addEventListener('click', () => this.enter('join', this.elements.codeInput.value.trim()));
+        this.elements.hostRoom.addEventListener('click', () => this.enter('create'));
+        this.elements.startParty.addEventListener('click', () => this.hostAction('start'));
+        this.elements.nextQuestion.addEventListener('click', () => this.hostAction('next'));
+    },
+
+    // Logs in and creates or joins a room
+    async enter(action, code) {
+        this.elements.errorMessage.textContent = '';
+        try {
+            await login(this.elements.passwordInput.value);
+            this.elements.passwordInput.value = '';
+            const player = this.elements.playerInput.value.trim();
+            localStorage.setItem('player', player);
+            this.room = await this.post(action, { code, player });
+            this.listen();
+        } catch (error) {
+            this.elements.errorMessage.textContent = error.message;
+        }
+    },
+
+    async hostAction(action) {
+        try {
+            await this.post(action, { code: this.room.code, hostToken: this.room.hostToken });
+        } catch (error) {
+            console.warn(`Could not ${action} the party:`, error);
+        }
+    },
+
+    async post(action, body) {
+        const response = await fetch(`party/${action}` + query, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        if (!response.ok) throw new Error((await response.text()).trim() || 'Request failed');
+        return response.status === 204 ? null : await response.json();
+    },
+
+    // Follows the events of the room; the browser reconnects on its own
+    listen() {
+        const url = 'party/events' + withParams({ code: this.room.code, player: this.room.playerId });
+        this.events = new EventSource(url);
+        this.events.addEventListener('lobby', e => this.showLobby(JSON.parse(e.data)));
+        this.events.addEventListener('question', e => this.showQuestion(JSON.parse(e.data)));
+        this.events.addEventListener('scores', e => this.showScores(JSON.parse(e.data)));
+    },
+
+    showPage(page) {
+        ['joinPage', 'lobbyPage', 'gamePage', 'scoresPage'].forEach(name =>
+            this.elements[name].classList.toggle('d-none', name !== page));
+    },
+
+    /** @param {import('./gameUtils.js').PartyLobby} lobby */
+    showLobby(lobby) {
+        if (lobby.phase !== 'lobby') return;
+        const host = Boolean(this.room.hostToken);
+        this.elements.roomCode.textContent = lobby.code;
+        this.elements.lobbyPlayers.replaceChildren(...lobby.players.map(name => {
+            const item = document.createElement('li');
+            item.textContent = name;
+            return item;
+        }));
+        this.elements.startParty.classList.toggle('d-none', !host);
+        this.elements.lobbyWaiting.classList.toggle('d-none', host);
+        this.elements.presentLink.href = 'present.html' + withParams({ code: lobby.code });
+        this.elements.presentLink.classList.toggle('d-none', !host);
+        this.showPage('lobbyPage');
+    },
+
+    /** @param {import('./gameUtils.js').PartyQuestion} question */
+    showQuestion(question) {
+        // A reconnect sends the open question again
+        if (question.index === this.questionIndex && this.picked !== null) return;
+        this.questionIndex = question.index;
+        this.picked = null;
+        this.elements.question.textContent = question.caption || `Question ${question.index + 1} of ${question.count}`;
+        const options = this.elements.options;
+        options.classList.toggle('options-grid', question.options.length > 2);
+        options.replaceChildren(...question.options.map((src, i) => {
+            const cell = document.createElement('div');
+            cell.className = `d-flex justify-content-${i % 2 === 0 ? 'end' : 'start'}`;
+            const figure = document.createElement('figure');
+            figure.className = 'm-0';
+            const img = document.createElement('img');
+            img.className = 'm-2';
+            setImageSource(img, src, OPTION_SIZES);
+            img.onclick = () => this.answer(question.index, i);
+            figure.appendChild(img);
+            cell.appendChild(figure);
+            return cell;
+        }));
+        this.startQuestionTimer(question.remainingMs);
+        this.showPage('gamePage');
+    },
+
+    startQuestionTimer(remainingMs) {
+        clearInterval(this.questionTimer);
+        const deadline = Date.now() + remainingMs;
+        const update = () => {
+            const timeLeft = Math.max(deadline - Date.now(), 0);
+            this.elements.questionTimer.textContent = `⏱ ${Math.ceil(timeLeft / 1000)}s`;
+            if (timeLeft === 0) clearInterval(this.questionTimer);
+        };
+        this.questionTimer = setInterval(update, 100);
+        update();
+    },
+
+    async answer(question, option) {
+        this.elements.options.querySelectorAll('img').forEach(img => img.onclick = null);
+        this.picked = option;
+        this.elements.question.textContent = 'Waiting for the others…';
+        try {
+            await this.post('answer', { code: this.room.code, playerId: this.room.playerId, question, option });
+        } catch (error) {
+            this.picked = null;
+            this.elements.question.textContent = 'Too slow! ⏱';
+        }
+    },
+
+    /** @param {import('./gameUtils.js').PartyScoreboard} scores */
+    showScores(scores) {
+        clearInterval(this.questionTimer);
+        if (this.picked === null) {
+            this.elements.answerResult.textContent = 'Too slow! ⏱';
+        } else {
+            this.elements.answerResult.textContent = this.picked === scores.answer ? 'Correct! 🎉' : 'Oops! 💩';
+        }
+        if (scores.finished) this.elements.answerResult.textContent += ' · Final scores';
+        this.elements.scoreboard.replaceChildren(...scores.scores.map((score, i) => {
+            const item = document.createElement('li');
+            const gained = score.gained ? ` (+${score.gained})` : '';
+            item.textContent = `${i + 1}. ${score.player} · ${score.points} pts${gained}`;
+            return item;
+        }));
+        this.elements.nextQuestion.classList.toggle('d-none', !this.room.hostToken || scores.finished);
+        this.showPage('scoresPage');
+        if (scores.finished) this.events.close();
+    }
+};
+
+document.addEventListener('DOMContentLoaded', () => Party.init());
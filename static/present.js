@@ -0,0 +1,198 @@
+// Presenter view: follows a party room or the solo games of a deck on a big screen
+import { loadConfig } from './configLoader.js';
+import { login, setImageSource, sleep, withParams } from './gameUtils.js';
+
+// How many solo games the feed keeps
+const SOLO_FEED_LENGTH = 10;
+
+const Present = {
+    elements: {},
+    events: null,
+    questionTimer: null,
+    options: [],
+    revealing: Promise.resolve(),
+
+    async init() {
+        this.config = await loadConfig();
+        this.cacheElements();
+        this.elements.title.textContent = `${this.config.APP_TITLE} 📺`;
+        this.elements.codeInput.value = new URLSearchParams(location.search).get('code') || '';
+        this.elements.present.addEventListener('click', () => this.start());
+        if (localStorage.getItem('theme') === 'dark') document.body.classList.add('theme-dark');
+    },
+
+    cacheElements() {
+        const byId = id => document.getElementById(id);
+        this.elements = {
+            title: byId('game-title'),
+            // Login Page
+            loginPage: byId('login-page'),
+            errorMessage: byId('error-message'),
+            passwordInput: byId('password-input'),
+            codeInput: byId('code-input'),
+            present: byId('present'),
+            // Lobby Page
+            lobbyPage: byId('lobby-page'),
+            roomCode: byId('room-code'),
+            lobbyPlayers: byId('lobby-players'),
+            // Game Page
+            gamePage: byId('game-page'),
+            questionTimer: byId('question-timer'),
+            question: byId('question'),
+            answerCount: byId('answer-count'),
+            options: byId('options'),
+            // Scores Page
+            scoresPage: byId('scores-page'),
+            winner: byId('winner'),
+            scoreboard: byId('scoreboard'),
+            // Solo Page
+            soloPage: byId('solo-page'),
+            soloFeed: byId('solo-feed')
+        };
+    },
+
+    async start() {
+        this.elements.errorMessage.textContent = '';
+        try {
+            await login(this.elements.passwordInput.value);
+            this.elements.passwordInput.value = '';
+            this.listen(this.elements.codeInput.value.trim());
+        } catch (error) {
+            this.elements.errorMessage.textContent = error.message;
+        }
+    },
+
+    // Follows the events of a room, or of the solo games without a code
+    listen(code) {
+        this.events = new EventSource('present' + withParams({ code }));
+        this.events.addEventListener('error', () => {
+            if (this.events.readyState === EventSource.CLOSED) this.elements.errorMessage.textContent = 'Room not found';
+        });
+        this.events.addEventListener('closed', () => this.events.close());
+        if (!code) {
+            this.events.addEventListener('started', e => this.addSolo(JSON.parse(e.data)));
+            this.events.addEventListener('finished', e => this.addSolo(JSON.parse(e.data)));
+            this.showPage('soloPage');
+            return;
+        }
+        this.events.addEventListener('lobby', e => this.showLobby(JSON.parse(e.data)));
+        this.events.addEventListener('question', e => this.showQuestion(JSON.parse(e.data)));
+        this.events.addEventListener('answers', e => this.showAnswers(JSON.parse(e.data)));
+        this.events.addEventListener('scores', e => this.showScores(JSON.parse(e.data)));
+        this.events.addEventListener('winner', e => this.showWinner(JSON.parse(e.data)));
+    },
+
+    showPage(page) {
+        ['loginPage', 'lobbyPage', 'gamePage', 'scoresPage', 'soloPage'].forEach(name =>
+            this.elements[name].classList.toggle('d-none', name !== page));
+    },
+
+    /** @param {import('./gameUtils.js').PartyLobby} lobby */
+    showLobby(lobby) {
+        if (lobby.phase !== 'lobby') return;
+        this.elements.roomCode.textContent = lobby.code;
+        this.elements.lobbyPlayers.replaceChildren(...lobby.players.map(name => {
+            const item = document.createElement('li');
+            item.textContent = name;
+            return item;
+        }));
+        this.showPage('lobbyPage');
+    },
+
+    /** @param {import('./gameUtils.js').PartyQuestion} question */
+    showQuestion(question) {
+        this.elements.question.textContent = question.caption || `Question ${question.index + 1} of ${question.count}`;
+        this.elements.answerCount.textContent = '';
+        const options = this.elements.options;
+        options.classList.toggle('options-grid', question.options.length > 2);
+        this.options = question.options.map((src, i) => {
+            const cell = document.createElement('div');
+            cell.className = `d-flex justify-content-${i % 2 === 0 ? 'end' : 'start'}`;
+            const figure = document.createElement('figure');
+            figure.className = 'm-0';
+            const img = document.createElement('img');
+            img.className = 'm-2';
+            setImageSource(img, src, '40vw');
+            figure.appendChild(img);
+            cell.appendChild(figure);
+            return figure;
+        });
+        options.replaceChildren(...this.options.map(figure => figure.parentElement));
+        this.startQuestionTimer(question.remainingMs);
+        this.showPage('gamePage');
+    },
+
+    startQuestionTimer(remainingMs) {
+        clearInterval(this.questionTimer);
+        const deadline = Date.now() + remainingMs;
+        const update = () => {
+            const timeLeft = Math.max(deadline - Date.now(), 0);
+            this.elements.questionTimer.textContent = `⏱ ${Math.ceil(timeLeft / 1000)}s`;
+            if (timeLeft === 0) clearInterval(this.questionTimer);
+        };
+        this.questionTimer = setInterval(update, 100);
+        update();
+    },
+
+    /** @param {import('./gameUtils.js').PartyAnswers} answers */
+    showAnswers(answers) {
+        this.elements.answerCount.textContent = `${answers.answered}/${answers.players} answered`;
+    },
+
+    /** @param {import('./gameUtils.js').PartyScoreboard} scores */
+    showScores(scores) {
+        clearInterval(this.questionTimer);
+        this.revealing = this.reveal(scores);
+    },
+
+    // Highlights the correct option for a moment before showing the scores
+    /** @param {import('./gameUtils.js').PartyScoreboard} scores */
+    async reveal(scores) {
+        if (!this.elements.gamePage.classList.contains('d-none')) {
+            this.options.forEach((figure, i) => {
+                figure.classList.toggle('option-correct', i === scores.answer);
+                const meta = scores.reveal?.[i];
+                const lines = [meta?.reveal, meta?.caption].filter(Boolean);
+                if (lines.length === 0) return;
+                const caption = document.createElement('figcaption');
+                caption.className = 'option-caption';
+                caption.textContent = lines.join(' · ');
+                figure.appendChild(caption);
+            });
+            await sleep(2500);
+        }
+        this.elements.winner.classList.add('d-none');
+        this.elements.scoreboard.replaceChildren(...scores.scores.map((score, i) => {
+            const item = document.createElement('li');
+            const gained = score.gained ? ` (+${score.gained})` : '';
+            item.textContent = `${i + 1}. ${score.player} · ${score.points} pts${gained}`;
+            return item;
+        }));
+        this.showPage('scoresPage');
+    },
+
+    /** @param {import('./gameUtils.js').PartyWinner} winner */
+    async showWinner(winner) {
+        await this.revealing;
+        this.elements.winner.textContent = `🏆 ${winner.players.join(' & ')} · ${winner.points} pts`;
+        this.elements.winner.classList.remove('d-none');
+        this.events.close();
+    },
+
+    /**
+     * @param {import('./gameUtils.js').SoloStarted | import('./gameUtils.js').ScoreEntry} game
+     */
+    addSolo(game) {
+        const item = document.createElement('li');
+        const mode = game.mode ? ` (${game.mode})` : '';
+        if ('finishedAt' in game) {
+            item.textContent = `${game.won ? '🎉' : '💩'} ${game.player}${mode} · ${game.points} pts · ${game.correct}/${game.total}`;
+        } else {
+            item.textContent = `▶️ ${game.player}${mode} started ${game.questionCount} questions`;
+        }
+        this.elements.soloFeed.prepend(item);
+        while (this.elements.soloFeed.children.length > SOLO_FEED_LENGTH) this.elements.soloFeed.lastChild.remove();
+    }
+};
+
+document.addEventListener('DOMContentLoaded', () => Present.init());
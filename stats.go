@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// difficultyEasy games prefer images that rarely fool players
+	difficultyEasy = "easy"
+	// difficultyHard games prefer images that often fool players
+	difficultyHard = "hard"
+)
+
+// answerStat is a single answered question as recorded in the statistics
+type answerStat struct {
+	Deck       string    `json:"deck"`
+	Images     []string  `json:"images"`  // the options of the question
+	Correct    int       `json:"correct"` // index of the correct option
+	Picked     int       `json:"picked"`  // index of the option the player picked
+	AnsweredAt time.Time `json:"answeredAt"`
+	// ResponseMs is the time from the question being available to the
+	// answer, as measured by the server
+	ResponseMs int64 `json:"responseMs,omitempty"`
+}
+
+// statKey identifies an image of a deck in the statistics
+type statKey struct {
+	deck  string
+	image string
+}
+
+// ImageStats sums up how an image fared in the questions showing it
+type ImageStats struct {
+	Shown     int `json:"shown"`
+	Correct   int `json:"correct"`   // questions showing the image answered correctly
+	Incorrect int `json:"incorrect"` // and answered wrongly
+	// Fooled counts the wrong answers the image caused: picked although it
+	// was wrong, or passed over although it was correct
+	Fooled int `json:"fooled"`
+
+	timed      int   // answers with a response time
+	responseMs int64 // summed over the timed answers
+}
+
+// MeanResponseMs is the average time taken to answer questions showing
+// the image, 0 if none was timed
+func (s ImageStats) MeanResponseMs() int64 {
+	if s.timed == 0 {
+		return 0
+	}
+	return s.responseMs / int64(s.timed)
+}
+
+// foolRate estimates how likely the image leads to a wrong answer. Images
+// with few answers stay close to even odds.
+func (s ImageStats) foolRate() float64 {
+	return float64(s.Fooled+1) / float64(s.Shown+2)
+}
+
+// statsLog keeps per-image statistics of all answered questions. When it
+// has a path, each answer is appended to that file as a line of JSON.
+type statsLog struct {
+	mu     sync.Mutex
+	path   string
+	images map[statKey]ImageStats
+}
+
+// stats records answered questions; main replaces it with one backed by a
+// file in the data directory
+var stats = newStatsLog()
+
+// newStatsLog returns statistics that are only kept in memory
+func newStatsLog() *statsLog {
+	return &statsLog{images: make(map[statKey]ImageStats)}
+}
+
+// openStatsLog loads the statistics saved at path, creating its directory
+// if needed. Lines that cannot be parsed are skipped.
+func openStatsLog(path string) (*statsLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	s := newStatsLog()
+	s.path = path
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var stat answerStat
+		if err := json.Unmarshal(scanner.Bytes(), &stat); err != nil {
+			log.Printf("Skipping invalid answer %s:%d: %v\n", path, line, err)
+			continue
+		}
+		s.add(stat)
+	}
+	return s, scanner.Err()
+}
+
+// record adds an answered question, writing it to disk before it counts
+func (s *statsLog) record(stat answerStat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.path != "" {
+		if err := appendJSONLine(s.path, stat); err != nil {
+			return err
+		}
+	}
+	s.add(stat)
+	return nil
+}
+
+// add counts an answered question; s.mu must be held unless s is not
+// shared yet
+func (s *statsLog) add(stat answerStat) {
+	correct := stat.Picked == stat.Correct
+	for i, img := range stat.Images {
+		key := statKey{deck: stat.Deck, image: img}
+		st := s.images[key]
+		st.Shown++
+		if correct {
+			st.Correct++
+		} else {
+			st.Incorrect++
+			if i == stat.Picked || i == stat.Correct {
+				st.Fooled++
+			}
+		}
+		if stat.ResponseMs > 0 {
+			st.timed++
+			st.responseMs += stat.ResponseMs
+		}
+		s.images[key] = st
+	}
+}
+
+// weight returns how images of a deck are weighed for games of the given
+// difficulty, or nil to pick them uniformly
+func (s *statsLog) weight(deck, difficulty string) func(img string) float64 {
+	if difficulty != difficultyEasy && difficulty != difficultyHard {
+		return nil
+	}
+	s.mu.Lock()
+	images := maps.Clone(s.images)
+	s.mu.Unlock()
+
+	return func(img string) float64 {
+		rate := images[statKey{deck: deck, image: img}].foolRate()
+		if difficulty == difficultyEasy {
+			return 1 - rate
+		}
+		return rate
+	}
+}
+
+// ImageReport is the statistics of one image in a report
+type ImageReport struct {
+	Deck  string `json:"deck"`
+	Image string `json:"image"`
+	ImageStats
+	MeanResponseMs int64 `json:"meanResponseMs"`
+}
+
+// StatsReport lists the statistics of every image that has been shown
+type StatsReport struct {
+	Images []ImageReport `json:"images"`
+}
+
+// report returns the statistics of the given deck, or of all decks if deck
+// is empty, ordered by deck and then from the most to the least fooling
+// image
+func (s *statsLog) report(deck string) StatsReport {
+	s.mu.Lock()
+	images := make([]ImageReport, 0, len(s.images))
+	for key, st := range s.images {
+		if deck == "" || key.deck == deck {
+			images = append(images, ImageReport{Deck: key.deck, Image: key.image, ImageStats: st, MeanResponseMs: st.MeanResponseMs()})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(images, func(i, j int) bool {
+		x, y := images[i], images[j]
+		if x.Deck != y.Deck {
+			return x.Deck < y.Deck
+		}
+		if rx, ry := x.foolRate(), y.foolRate(); rx != ry {
+			return rx > ry
+		}
+		return x.Image < y.Image
+	})
+	return StatsReport{Images: images}
+}
+
+// writeCSV writes the report as CSV with a header line
+func (r StatsReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"deck", "image", "shown", "correct", "incorrect", "fooled", "mean_response_ms"})
+	for _, img := range r.Images {
+		cw.Write([]string{
+			img.Deck,
+			img.Image,
+			strconv.Itoa(img.Shown),
+			strconv.Itoa(img.Correct),
+			strconv.Itoa(img.Incorrect),
+			strconv.Itoa(img.Fooled),
+			strconv.FormatInt(img.MeanResponseMs, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseDifficulty checks the difficulty requested by a client
+func parseDifficulty(s string) (string, error) {
+	switch s {
+	case "", difficultyEasy, difficultyHard:
+		return s, nil
+	}
+	return "", fmt.Errorf("difficulty must be %s or %s, got %q", difficultyEasy, difficultyHard, s)
+}
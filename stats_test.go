@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestStatsLogPersists tests counting answers and reloading them
+func TestStatsLogPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "answers.jsonl")
+	s, err := openStatsLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stat := range []answerStat{
+		{Deck: "alice", Images: []string{"a.jpg", "b.jpg"}, Correct: 0, Picked: 0},
+		{Deck: "alice", Images: []string{"a.jpg", "c.jpg", "d.jpg"}, Correct: 0, Picked: 1},
+	} {
+		stat.AnsweredAt = time.Now()
+		if err := s.record(stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A line cut short by a crash is skipped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"deck":"ali`)
+	f.Close()
+
+	s, err = openStatsLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		img  string
+		want ImageStats
+	}{
+		{"a.jpg", ImageStats{Shown: 2, Correct: 1, Incorrect: 1, Fooled: 1}},
+		{"b.jpg", ImageStats{Shown: 1, Correct: 1}},
+		{"c.jpg", ImageStats{Shown: 1, Incorrect: 1, Fooled: 1}},
+		{"d.jpg", ImageStats{Shown: 1, Incorrect: 1}},
+	}
+	for _, tt := range tests {
+		if got := s.images[statKey{"alice", tt.img}]; got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.img, tt.want, got)
+		}
+	}
+}
+
+// TestStatsLogWeight tests that hard games prefer images that fool players
+// and easy games the others
+func TestStatsLogWeight(t *testing.T) {
+	s := newStatsLog()
+	for i := 0; i < 20; i++ {
+		s.record(answerStat{Deck: "alice", Images: []string{"a.jpg", "tricky.jpg", "obvious.jpg"}, Correct: 0, Picked: 1})
+	}
+
+	if s.weight("alice", "") != nil {
+		t.Error("Expected uniform picks without a difficulty")
+	}
+	hard, easy := s.weight("alice", difficultyHard), s.weight("alice", difficultyEasy)
+	if hard("tricky.jpg") <= hard("obvious.jpg") || hard("unknown.jpg") <= hard("obvious.jpg") {
+		t.Errorf("Unexpected hard weights: tricky %f, obvious %f, unknown %f", hard("tricky.jpg"), hard("obvious.jpg"), hard("unknown.jpg"))
+	}
+	if easy("obvious.jpg") <= easy("tricky.jpg") {
+		t.Errorf("Unexpected easy weights: tricky %f, obvious %f", easy("tricky.jpg"), easy("obvious.jpg"))
+	}
+}
+
+// TestWeightedShuffle tests that heavier images tend to come first
+func TestWeightedShuffle(t *testing.T) {
+	weight := func(img string) float64 {
+		if img == "heavy.jpg" {
+			return 0.95
+		}
+		return 0.05
+	}
+	r := newRand(1)
+	first := 0
+	for i := 0; i < 200; i++ {
+		images := []string{"a.jpg", "b.jpg", "heavy.jpg", "c.jpg"}
+		weightedShuffle(r, images, weight)
+		if images[0] == "heavy.jpg" {
+			first++
+		}
+	}
+	if first < 150 {
+		t.Errorf("Expected the heavy image first most of the time, got %d/200", first)
+	}
+}
+
+// TestParseDifficulty tests the difficulty parameter
+func TestParseDifficulty(t *testing.T) {
+	for _, ok := range []string{"", difficultyEasy, difficultyHard} {
+		if _, err := parseDifficulty(ok); err != nil {
+			t.Errorf("parseDifficulty(%q) failed: %v", ok, err)
+		}
+	}
+	if _, err := parseDifficulty("insane"); err == nil {
+		t.Error("Expected an error for an unknown difficulty")
+	}
+}
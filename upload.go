@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"whos-your-mate/config"
+)
+
+// imageKinds are the image directories of a deck, as named in admin requests
+var imageKinds = []string{"choice_a", "choice_b", "ending"}
+
+// imageContentTypes is the content type sniffed from the files of every
+// supported extension
+var imageContentTypes = map[string]string{
+	".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".gif": "image/gif", ".webp": "image/webp",
+}
+
+// sniffLength is how much of a file http.DetectContentType looks at
+const sniffLength = 512
+
+// maxUploadSize is the largest image the admin upload accepts; main sets it
+// from the configuration
+var maxUploadSize int64 = 10 << 20
+
+// maxUploadBatch is how many images of maxUploadSize a single upload
+// request may hold
+const maxUploadBatch = 20
+
+var (
+	errBadImagePath     = errors.New("invalid image path")
+	errBadUpload        = errors.New("invalid upload")
+	errUnsupportedImage = errors.New("unsupported image")
+	errImageTooLarge    = errors.New("image too large")
+	errImageExists      = errors.New("image already exists")
+)
+
+// imageFilesMu serializes changes to the image directories, so that two
+// uploads of the same name cannot overwrite each other
+var imageFilesMu sync.Mutex
+
+// AdminImage is an image file of a deck
+type AdminImage struct {
+	Dir     string    `json:"dir"`  // choice_a, choice_b or ending
+	Name    string    `json:"name"` // path within dir, e.g. "perfect/cake.jpg"
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// AdminImages lists the image files of a deck
+type AdminImages struct {
+	Deck   string       `json:"deck"`
+	Images []AdminImage `json:"images"`
+}
+
+// ImageMove moves an image to another directory, keeping its file name
+type ImageMove struct {
+	Dir  string `json:"dir"`
+	Name string `json:"name"`
+	To   string `json:"to"` // e.g. "choice_b" or "ending/good"
+}
+
+// resolveDir returns the path of an image directory of a catalog given as
+// its kind, optionally followed by a subdirectory such as "ending/perfect"
+func resolveDir(c *imageCatalog, dir string) (string, error) {
+	kind, sub, _ := strings.Cut(dir, "/")
+	base, ok := c.dir(kind)
+	if !ok {
+		return "", fmt.Errorf("%w: dir must be one of %s, got %q", errBadImagePath, strings.Join(imageKinds, ", "), kind)
+	}
+	if sub == "" {
+		return base, nil
+	}
+	if !filepath.IsLocal(filepath.FromSlash(sub)) {
+		return "", fmt.Errorf("%w: %q", errBadImagePath, dir)
+	}
+	return filepath.Join(base, filepath.FromSlash(sub)), nil
+}
+
+// resolveImage returns the path of an image file within an image directory.
+// Hidden files and unsupported extensions are refused.
+func resolveImage(c *imageCatalog, dir, name string) (string, error) {
+	base, err := resolveDir(c, dir)
+	if err != nil {
+		return "", err
+	}
+	local := filepath.FromSlash(name)
+	if !filepath.IsLocal(local) || strings.HasPrefix(filepath.Base(local), ".") {
+		return "", fmt.Errorf("%w: %q", errBadImagePath, name)
+	}
+	if !supportExtensions[strings.ToLower(filepath.Ext(local))] {
+		return "", fmt.Errorf("%w: %q is not one of the supported extensions", errUnsupportedImage, name)
+	}
+	return filepath.Join(base, local), nil
+}
+
+// locateImage returns the directory and name of an indexed image path of
+// a catalog
+func locateImage(c *imageCatalog, p string) ImageRef {
+	for _, kind := range imageKinds {
+		base, _ := c.dir(kind)
+		if rel, err := filepath.Rel(base, p); err == nil && filepath.IsLocal(rel) {
+			return ImageRef{Dir: kind, Name: filepath.ToSlash(rel)}
+		}
+	}
+	return ImageRef{Name: filepath.ToSlash(p)}
+}
+
+// listImages returns the image files of every directory of a catalog
+func listImages(c *imageCatalog) ([]AdminImage, error) {
+	images := []AdminImage{}
+	for _, kind := range imageKinds {
+		base, _ := c.dir(kind)
+		err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if p == base && errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !supportExtensions[strings.ToLower(filepath.Ext(p))] {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(base, p)
+			images = append(images, AdminImage{Dir: kind, Name: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// createImageDirs creates the missing image directories of a deck, so that
+// a deck without images can be indexed and filled through the upload
+func createImageDirs(deck *config.Deck) {
+	for _, dir := range imageCheckDirs(deck) {
+		if err := os.MkdirAll(dir.Path, 0755); err != nil {
+			log.Printf("Could not create %s directory %s: %v\n", dir.Label, dir.Path, err)
+		}
+	}
+}
+
+// saveImage writes an image to path, which must not exist yet. The content
+// must match the extension of path and be at most limit bytes. The image
+// is written to a temporary file next to path first, so that the catalog
+// never indexes a partial file.
+func saveImage(p string, r io.Reader, limit int64) error {
+	content := bufio.NewReaderSize(r, sniffLength)
+	head, err := content.Peek(sniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	ext := strings.ToLower(filepath.Ext(p))
+	if sniffed := http.DetectContentType(head); sniffed != imageContentTypes[ext] {
+		return fmt.Errorf("%w: %s content is not %s", errUnsupportedImage, ext, sniffed)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	// Temporary files have no image extension, so rescans skip them
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, io.LimitReader(content, limit+1))
+	if err == nil && n > limit {
+		err = fmt.Errorf("%w: larger than %d bytes", errImageTooLarge, limit)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	imageFilesMu.Lock()
+	defer imageFilesMu.Unlock()
+	if _, err := os.Stat(p); err == nil {
+		return fmt.Errorf("%w: %s", errImageExists, filepath.Base(p))
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// moveImage moves an image and its metadata sidecar file, refusing to
+// overwrite an existing image. Resized variants are made again on demand.
+func moveImage(from, to string) error {
+	imageFilesMu.Lock()
+	defer imageFilesMu.Unlock()
+	if _, err := os.Stat(from); err != nil {
+		return err
+	}
+	if _, err := os.Stat(to); err == nil {
+		return fmt.Errorf("%w: %s", errImageExists, filepath.Base(to))
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if err := os.Rename(from+".json", to+".json"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Could not move metadata of %s: %v\n", from, err)
+	}
+	variants.forget(from)
+	return nil
+}
+
+// deleteImage removes an image, its metadata sidecar file and its resized
+// variants
+func deleteImage(p string) error {
+	imageFilesMu.Lock()
+	defer imageFilesMu.Unlock()
+	if err := os.Remove(p); err != nil {
+		return err
+	}
+	if err := os.Remove(p + ".json"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Could not delete metadata of %s: %v\n", p, err)
+	}
+	variants.forget(p)
+	return nil
+}
+
+// adminImagesHandler manages the image files of the deck given with ?deck=.
+// GET lists them, POST uploads the "image" files of a multipart form to
+// ?dir= and DELETE removes the image ?name= from ?dir=. Changes are indexed
+// right away.
+func adminImagesHandler(w http.ResponseWriter, r *http.Request) {
+	deck := deckOf(r).Name
+	c, ok := catalogs[deck]
+	if !ok {
+		respondWithError(w, "Deck has no images", fmt.Errorf("no catalog for deck %s", deck))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		images, err := listImages(c)
+		if err != nil {
+			respondWithError(w, "Could not list images", err)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, AdminImages{Deck: deck, Images: images})
+	case http.MethodPost:
+		limit := maxUploadBatch * maxUploadSize
+		if r.ContentLength > limit {
+			http.Error(w, fmt.Sprintf("upload larger than %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		uploaded, err := uploadImages(c, r)
+		if len(uploaded) > 0 {
+			refreshCatalog(deck, c)
+		}
+		if err != nil {
+			adminImageError(w, err)
+			return
+		}
+		writeAdminJSON(w, http.StatusCreated, uploaded)
+	case http.MethodDelete:
+		p, err := resolveImage(c, r.URL.Query().Get("dir"), r.URL.Query().Get("name"))
+		if err == nil {
+			err = deleteImage(p)
+		}
+		if err != nil {
+			adminImageError(w, err)
+			return
+		}
+		refreshCatalog(deck, c)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPost, http.MethodDelete}, ", "))
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// uploadImages saves the "image" parts of a multipart request in the
+// directory given with ?dir= and returns the images saved before any error
+func uploadImages(c *imageCatalog, r *http.Request) ([]AdminImage, error) {
+	dir := r.URL.Query().Get("dir")
+	if _, err := resolveDir(c, dir); err != nil {
+		return nil, err
+	}
+	parts, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadUpload, err)
+	}
+
+	var uploaded []AdminImage
+	for {
+		part, err := parts.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// A body cut off at its limit also fails to parse
+			return uploaded, fmt.Errorf("%w: %w", errBadUpload, err)
+		}
+		if part.FormName() != "image" || part.FileName() == "" {
+			continue
+		}
+		// FileName has already dropped any directories of the client
+		name := part.FileName()
+		p, err := resolveImage(c, dir, name)
+		if err != nil {
+			return uploaded, err
+		}
+		if err := saveImage(p, part, maxUploadSize); err != nil {
+			return uploaded, err
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return uploaded, err
+		}
+		kind, sub, _ := strings.Cut(dir, "/")
+		uploaded = append(uploaded, AdminImage{Dir: kind, Name: path.Join(sub, name), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	if len(uploaded) == 0 {
+		return nil, fmt.Errorf("%w: no image in the form", errBadUpload)
+	}
+	return uploaded, nil
+}
+
+// adminMoveImageHandler moves an image of the deck given with ?deck= to
+// another directory, e.g. from choice_b to choice_a
+func adminMoveImageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deck := deckOf(r).Name
+	c, ok := catalogs[deck]
+	if !ok {
+		respondWithError(w, "Deck has no images", fmt.Errorf("no catalog for deck %s", deck))
+		return
+	}
+
+	if !isJSON(r) {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var req ImageMove
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	from, err := resolveImage(c, req.Dir, req.Name)
+	if err != nil {
+		adminImageError(w, err)
+		return
+	}
+	name := path.Base(req.Name)
+	to, err := resolveImage(c, req.To, name)
+	if err == nil {
+		err = moveImage(from, to)
+	}
+	if err != nil {
+		adminImageError(w, err)
+		return
+	}
+	refreshCatalog(deck, c)
+
+	info, err := os.Stat(to)
+	if err != nil {
+		respondWithError(w, "Could not read moved image", err)
+		return
+	}
+	kind, sub, _ := strings.Cut(req.To, "/")
+	writeAdminJSON(w, http.StatusOK, AdminImage{Dir: kind, Name: path.Join(sub, name), Size: info.Size(), ModTime: info.ModTime()})
+}
+
+// refreshCatalog indexes the images of a deck after they changed
+func refreshCatalog(deck string, c *imageCatalog) {
+	if err := c.Refresh(); err != nil {
+		log.Printf("Could not rescan images of deck %s: %v\n", deck, err)
+	}
+}
+
+// adminImageError sends the status matching an error of the image API
+func adminImageError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		http.Error(w, fmt.Sprintf("upload larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errBadImagePath), errors.Is(err, errBadUpload):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errUnsupportedImage):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, errImageTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errImageExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, "Image not found", http.StatusNotFound)
+	default:
+		respondWithError(w, "Could not change images", err)
+	}
+}
+
+// writeAdminJSON sends a response of the admin API
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Could not encode response:", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"whos-your-mate/config"
+)
+
+// pngHeader is enough of a PNG file for content sniffing
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// withTestCatalog makes a catalog of temporary directories with the given
+// numbers of images the default deck for the duration of a test
+func withTestCatalog(t *testing.T, choiceA, choiceB, ending int) *imageCatalog {
+	t.Helper()
+	choiceADir, choiceBDir, endingDir := newTestCatalogDirs(t, choiceA, choiceB, ending)
+	c := newImageCatalog(choiceADir, choiceBDir, endingDir, "")
+	if err := c.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	saved := catalogs
+	catalogs = map[string]*imageCatalog{config.DefaultDeck: c}
+	t.Cleanup(func() { catalogs = saved })
+	return c
+}
+
+// uploadRequest builds a multipart upload of the given files by name
+func uploadRequest(t *testing.T, dir string, files map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := form.CreateFormFile("image", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/admin/images?dir="+dir, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+// moveRequest builds a request to move an image
+func moveRequest(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/admin/images/move", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// TestImageContentTypes tests that every supported extension can be sniffed
+func TestImageContentTypes(t *testing.T) {
+	for ext := range supportExtensions {
+		if imageContentTypes[ext] == "" {
+			t.Errorf("Expected a content type for %s", ext)
+		}
+	}
+}
+
+// TestResolveImage tests which image paths the admin API accepts
+func TestResolveImage(t *testing.T) {
+	c := newImageCatalog("/srv/a", "/srv/b", "/srv/end", "")
+	tests := []struct {
+		dir     string
+		name    string
+		want    string
+		wantErr error
+	}{
+		{"choice_a", "cake.jpg", "/srv/a/cake.jpg", nil},
+		{"ending/perfect", "cake.PNG", "/srv/end/perfect/cake.PNG", nil},
+		{"ending", "good/cake.jpg", "/srv/end/good/cake.jpg", nil},
+		{"choice_c", "cake.jpg", "", errBadImagePath},
+		{"ending/../../etc", "cake.jpg", "", errBadImagePath},
+		{"choice_a", "../b/cake.jpg", "", errBadImagePath},
+		{"choice_a", "/etc/cake.jpg", "", errBadImagePath},
+		{"choice_a", ".hidden.jpg", "", errBadImagePath},
+		{"choice_a", "cake.json", "", errUnsupportedImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir+"/"+tt.name, func(t *testing.T) {
+			got, err := resolveImage(c, tt.dir, tt.name)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestSaveImage tests content sniffing, the size limit and refusing to
+// overwrite images
+func TestSaveImage(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{"cake.png", pngHeader + "pixels", nil},
+		{"cake.png", pngHeader + "again", errImageExists},
+		{"fake.jpg", pngHeader + "pixels", errUnsupportedImage},
+		{"script.gif", "<html><script>", errUnsupportedImage},
+		{"large.png", pngHeader + strings.Repeat("x", 64), errImageTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := saveImage(filepath.Join(dir, tt.name), strings.NewReader(tt.content), 32)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "cake.png" {
+		t.Errorf("Expected only cake.png and no temporary files, got %v", entries)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "cake.png")); string(b) != pngHeader+"pixels" {
+		t.Errorf("Expected the first upload to be kept, got %q", b)
+	}
+}
+
+// TestAdminImagesHandler tests uploading, listing, moving and deleting
+// images of a deck
+func TestAdminImagesHandler(t *testing.T) {
+	c := withTestCatalog(t, 2, 2, 1)
+
+	w := httptest.NewRecorder()
+	adminImagesHandler(w, uploadRequest(t, "ending/perfect", map[string]string{"cake.png": pngHeader + "pixels"}))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var uploaded []AdminImage
+	if err := json.NewDecoder(w.Body).Decode(&uploaded); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(uploaded) != 1 || uploaded[0].Dir != "ending" || uploaded[0].Name != "perfect/cake.png" {
+		t.Errorf("Unexpected upload: %+v", uploaded)
+	}
+	if endings := c.Snapshot().Endings["perfect"]; len(endings) != 1 {
+		t.Errorf("Expected the upload to be indexed as a perfect ending, got %v", endings)
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"unknown dir", uploadRequest(t, "choice_c", map[string]string{"cake.png": pngHeader}), http.StatusBadRequest},
+		{"wrong content", uploadRequest(t, "choice_a", map[string]string{"cake.jpg": pngHeader}), http.StatusUnsupportedMediaType},
+		{"existing image", uploadRequest(t, "ending/perfect", map[string]string{"cake.png": pngHeader}), http.StatusConflict},
+		{"no multipart form", httptest.NewRequest(http.MethodPost, "/admin/images?dir=choice_a", nil), http.StatusBadRequest},
+		{"delete unknown image", httptest.NewRequest(http.MethodDelete, "/admin/images?dir=choice_a&name=nope.jpg", nil), http.StatusNotFound},
+		{"method not allowed", httptest.NewRequest(http.MethodPut, "/admin/images", nil), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			adminImagesHandler(w, tt.req)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// Moving an image takes its sidecar metadata along
+	if err := os.WriteFile(filepath.Join(c.choiceBDir, "imga.jpg.json"), []byte(`{"caption":"Lisbon"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	adminMoveImageHandler(w, httptest.NewRequest(http.MethodPost, "/admin/images/move", strings.NewReader(`{"dir":"choice_b","name":"imga.jpg","to":"choice_a"}`)))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected a move without a JSON content type to be refused, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	adminMoveImageHandler(w, moveRequest(`{"dir":"choice_b","name":"imga.jpg","to":"choice_a"}`))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected moving onto an existing image to conflict, got %d", w.Code)
+	}
+	os.Remove(filepath.Join(c.choiceADir, "imga.jpg"))
+	w = httptest.NewRecorder()
+	adminMoveImageHandler(w, moveRequest(`{"dir":"choice_b","name":"imga.jpg","to":"choice_a"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	images := c.Snapshot()
+	moved := filepath.Join(c.choiceADir, "imga.jpg")
+	if len(images.ChoiceA) != 2 || len(images.ChoiceB) != 1 || images.Meta[moved].Caption != "Lisbon" {
+		t.Errorf("Expected the image and its caption in choice_a, got %v, %v, %v", images.ChoiceA, images.ChoiceB, images.Meta)
+	}
+
+	w = httptest.NewRecorder()
+	adminImagesHandler(w, httptest.NewRequest(http.MethodDelete, "/admin/images?dir=choice_a&name=imga.jpg", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(moved + ".json"); !os.IsNotExist(err) {
+		t.Error("Expected the sidecar to be deleted with its image")
+	}
+
+	w = httptest.NewRecorder()
+	adminImagesHandler(w, httptest.NewRequest(http.MethodGet, "/admin/images", nil))
+	var list AdminImages
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if list.Deck != config.DefaultDeck || len(list.Images) != 4 {
+		t.Errorf("Expected 4 images after the changes, got %+v", list)
+	}
+
+	// The request as a whole is limited, not just each image in it
+	saved := maxUploadSize
+	maxUploadSize = 16
+	defer func() { maxUploadSize = saved }()
+	files := make(map[string]string)
+	for _, name := range []string{"a.png", "b.png", "c.png", "d.png"} {
+		files[name] = pngHeader
+	}
+	w = httptest.NewRecorder()
+	adminImagesHandler(w, uploadRequest(t, "ending/good", files))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d: %s", w.Code, w.Body.String())
+	}
+	// Bodies of unknown length are cut off at the limit
+	req := uploadRequest(t, "ending/good", files)
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	adminImagesHandler(w, req)
+	if w.Code != http.StatusBadRequest && w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 400 or 413, got %d: %s", w.Code, w.Body.String())
+	}
+}